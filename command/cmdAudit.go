@@ -0,0 +1,117 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/StackAdapt/systags/manager"
+)
+
+type AuditCommand struct {
+	baseCommand
+	key    string
+	since  string
+	until  string
+	verify bool
+}
+
+func NewAuditCommand() *AuditCommand {
+
+	cmd := &AuditCommand{
+		baseCommand: baseCommand{
+			flagSet: flag.NewFlagSet("", flag.ContinueOnError),
+		},
+	}
+
+	cmd.flagSet.StringVar(&cmd.key, "k", "", "")
+	cmd.flagSet.StringVar(&cmd.key, "key", "", "")
+	cmd.flagSet.StringVar(&cmd.since, "s", "", "")
+	cmd.flagSet.StringVar(&cmd.since, "since", "", "")
+	cmd.flagSet.StringVar(&cmd.until, "u", "", "")
+	cmd.flagSet.StringVar(&cmd.until, "until", "", "")
+	cmd.flagSet.BoolVar(&cmd.verify, "v", false, "")
+	cmd.flagSet.BoolVar(&cmd.verify, "verify", false, "")
+
+	// Don't print unneeded usage
+	cmd.flagSet.Usage = func() {}
+
+	return cmd
+}
+
+// parseTime accepts either an RFC 3339 timestamp or a
+// duration which is subtracted from the current time
+func parseTime(value string) (time.Time, error) {
+
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+
+	return time.Now().Add(-d), nil
+}
+
+func (cmd *AuditCommand) Parse(args []string) error {
+
+	err := cmd.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if _, err := parseTime(cmd.since); err != nil {
+		return errors.New("flag has unsupported value: -since")
+	}
+
+	if _, err := parseTime(cmd.until); err != nil {
+		return errors.New("flag has unsupported value: -until")
+	}
+
+	return nil
+}
+
+func (cmd *AuditCommand) Apply(m *manager.Manager) error {
+
+	if cmd.verify {
+
+		count, err := m.VerifyAudit()
+		if err != nil {
+			return err
+		}
+
+		m.GetLogger().Info(fmt.Sprintf("verified %d chained entries", count))
+
+		return nil
+	}
+
+	since, _ := parseTime(cmd.since)
+	until, _ := parseTime(cmd.until)
+
+	entries, err := m.Audit(cmd.key, since, until)
+	if err != nil {
+		return err
+	}
+
+	if entries == nil {
+		entries = []manager.AuditEntry{}
+	}
+
+	// Attempt to convert the entries to JSON
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	m.GetLogger().Info(string(out))
+
+	return nil
+}
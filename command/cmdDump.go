@@ -37,17 +37,9 @@ func (cmd *DumpCommand) Parse(args []string) error {
 		return err
 	}
 
-	switch cmd.kind {
-	case "config":
-	case "remote":
-	case "system":
-		break
-
-	case "":
+	// Layers are only known once the files are loaded
+	if cmd.kind == "" {
 		return errors.New("flag needs to be provided: -kind")
-
-	default:
-		return errors.New("flag has unsupported value: -kind")
 	}
 
 	return nil
@@ -60,17 +52,9 @@ func (cmd *DumpCommand) Apply(m *manager.Manager) error {
 		return err
 	}
 
-	var tags manager.Tags
-
-	switch cmd.kind {
-	case "config":
-		tags = m.ConfigTags()
-
-	case "remote":
-		tags = m.RemoteTags()
-
-	case "system":
-		tags = m.SystemTags()
+	tags, err := m.LayerTags(cmd.kind)
+	if err != nil {
+		return errors.New("flag has unsupported value: -kind")
 	}
 
 	// Attempt to convert the remote data to JSON
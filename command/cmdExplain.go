@@ -0,0 +1,68 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+
+	"github.com/StackAdapt/systags/manager"
+)
+
+type ExplainCommand struct {
+	baseCommand
+	key string
+}
+
+func NewExplainCommand() *ExplainCommand {
+
+	cmd := &ExplainCommand{
+		baseCommand: baseCommand{
+			flagSet: flag.NewFlagSet("", flag.ContinueOnError),
+		},
+	}
+
+	cmd.flagSet.StringVar(&cmd.key, "k", "", "")
+	cmd.flagSet.StringVar(&cmd.key, "key", "", "")
+
+	// Don't print unneeded usage
+	cmd.flagSet.Usage = func() {}
+
+	return cmd
+}
+
+func (cmd *ExplainCommand) Parse(args []string) error {
+
+	err := cmd.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.key == "" {
+		return errors.New("flag needs to be provided: -key")
+	}
+
+	return nil
+}
+
+func (cmd *ExplainCommand) Apply(m *manager.Manager) error {
+
+	err := m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	prov, found := m.ExplainTag(cmd.key)
+	if !found {
+		return errors.New("tag not found: " + cmd.key)
+	}
+
+	// Attempt to convert the provenance to JSON
+	out, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	m.GetLogger().Info(string(out))
+
+	return nil
+}
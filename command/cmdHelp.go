@@ -37,15 +37,29 @@ func (cmd *HelpCommand) Apply(m *manager.Manager) error {
 
 			init
 				-r|-reset (bool) [optional = false]
+				-m|-mode (octal) [optional = 0644]
+				-d|-dir-mode (octal) [optional = 0755]
+				-o|-owner (user or uid) [optional = ""]
+				-g|-group (group or gid) [optional = ""]
 
 			dump
 				-k|-kind (string) [required]
-					config, remote, system
+					config, remote, system, or any layer
 
 			update
 				-t|-timeout (duration) [optional = 5*time.Second]
 				-r|-retry (duration) [optional = 0*time.Second]
 				-k|-keys (string) [optional = ""]
+				-p|-provider (string) [optional = $SYSTAGS_PROVIDER]
+					auto, aws, gcp, azure, kubernetes, exec
+					(comma-separated, auto is the first detected
+					of aws, gcp, azure, e.g. auto,exec)
+				-i|-identity (bool) [optional = false]
+				-n|-namespace (string) [optional = "aws:"]
+				-imds-endpoint (string) [optional = $SYSTAGS_AWS_IMDS_ENDPOINT]
+				-ec2-endpoint (string) [optional = $SYSTAGS_AWS_EC2_ENDPOINT]
+				-s|-strict (bool) [optional = false]
+				-v|-validate (bool) [optional = false]
 
 			ls
 				-r|-regex (bool) [optional = false]
@@ -55,11 +69,22 @@ func (cmd *HelpCommand) Apply(m *manager.Manager) error {
 				-o|-omit (string) [optional = ""]
 				-e|-prefix (string) [optional = ""]
 				-u|-suffix (string) [optional = ""]
+				-a|-max-age (duration) [optional = 0*time.Second]
+				-x|-explain (bool) [optional = false]
 
 			get
 				-k|-key     (string) [required]
 				-d|-default (string) [optional = ""]
 
+			explain
+				-k|-key (string) [required]
+
+			lint
+				-s|-strict (bool) [optional = $SYSTAGS_STRICT]
+
+			validate
+				<none>
+
 			set
 				-k|-key   (string) [required]
 				-v|-value (string) [required]
@@ -67,18 +92,159 @@ func (cmd *HelpCommand) Apply(m *manager.Manager) error {
 			rm
 				-k|-key (string) [required]
 
+			status
+				<none>
+
+			history
+				<none>
+
+			rollback
+				-t|-to (string) [required]
+					generation number or ID
+
+			audit
+				-k|-key (string) [optional = ""]
+				-s|-since (time or duration) [optional = ""]
+				-u|-until (time or duration) [optional = ""]
+				-v|-verify (bool) [optional = false]
+
 			version
 				<none>
 
 		Env:
 			SYSTAGS_CONFIG_DIR
-				=/etc/systags.d
+				=/usr/lib/systags.d:/etc/systags.d:/run/systags.d
+
+			SYSTAGS_CONFIG_RECURSIVE
+				=
 
 			SYSTAGS_SYSTEM_DIR
 				=/var/lib/systags
 
 			SYSTAGS_DEBUG
 				=
+
+			SYSTAGS_LOCK_TIMEOUT
+				=10s
+
+			SYSTAGS_STRICT
+				=
+
+			SYSTAGS_STORE
+				=file (bolt)
+
+			SYSTAGS_HISTORY_LIMIT
+				=10
+
+			SYSTAGS_AUDIT_CHAIN
+				=
+
+			SYSTAGS_FILE_MODE
+				=0644
+
+			SYSTAGS_DIR_MODE
+				=0755
+
+			SYSTAGS_OWNER
+				=
+
+			SYSTAGS_GROUP
+				=
+
+			SYSTAGS_PROVIDER
+				=auto
+
+			SYSTAGS_AWS_IMDS_ENDPOINT
+				=http://169.254.169.254
+
+			SYSTAGS_AWS_EC2_ENDPOINT
+				=
+
+			GCE_METADATA_HOST
+				=metadata.google.internal
+
+			SYSTAGS_GCP_COMPUTE_ENDPOINT
+				=https://compute.googleapis.com/compute/v1
+
+			SYSTAGS_GCP_ATTRIBUTES
+				=
+
+			SYSTAGS_AZURE_ENDPOINT
+				=http://169.254.169.254/metadata
+
+			SYSTAGS_KUBECONFIG
+				=
+
+			SYSTAGS_KUBE_NODE
+				=$NODE_NAME
+
+			SYSTAGS_KUBE_PREFIX
+				=
+
+			SYSTAGS_KUBE_ANNOTATIONS
+				=
+
+			SYSTAGS_EXEC_DIR
+				=/etc/systags.d/providers
+
+		Config files:
+			.json, .yaml, .yml, .toml and .env files in a layer's
+			directories are merged in order, later files winning:
+			names starting with a number come first ordered by
+			that number (9-a.json before 10-b.json), then the rest
+			by name. Keys defined by multiple files are warned
+			about, or fail with SYSTAGS_STRICT. A file masks the
+			file with the same name in earlier directories of the
+			search path, and a symlink to /dev/null masks it
+			entirely. With SYSTAGS_CONFIG_RECURSIVE, files in
+			subdirectories are included by their relative path.
+
+		Schema:
+			An optional schema.json in the config directories
+			declares rules for keys, enforced by set and validate,
+			and by update -validate for remote values:
+				{"keys": {"env": {"type": "enum", "required": true,
+					"values": ["prod", "staging", "dev"]}}}
+			Types are string (default), int, bool, and enum, and a
+			pattern must match the whole value.
+
+		Layers:
+			An optional layers.json in the config directories adds
+			layers of tags, where higher priorities take precedence
+			over the built-in remote (10), config (20), and system
+			(30) layers. Sources are dir, a colon-separated search
+			path of config directories, file, a single config file,
+			and env, variables starting with a prefix which is
+			stripped and the rest lower cased to get keys:
+				{"layers": [
+					{"name": "team", "priority": 25, "source": "dir", "path": "/etc/team.d"},
+					{"name": "site", "priority": 15, "source": "file", "path": "/etc/site.json"},
+					{"name": "env", "priority": 40, "source": "env", "prefix": "SYSTAGS_TAG_"}
+				]}
+			Built-in layers can be listed with only a new priority,
+			and no two layers can share the same priority.
+
+		Policy:
+			An optional policy.json in the config directories locks
+			keys, or shell patterns of keys, to the layer they must
+			come from. Values from other layers are ignored, and set
+			and rm fail unless the key is locked to system:
+				{"locked": {"env": "remote", "aws:*": "remote"}}
+
+		Templates:
+			Values from config files can reference other tags,
+			expanded after all layers are merged:
+				${key}                   value of key
+				${key:-default}          default when key is missing
+				${key|lower}             also upper, replace(old,new)
+				$${                      a literal ${
+			Values with invalid or cyclic templates are kept as is
+			and warned about, or fail with SYSTAGS_STRICT.
+
+		Exit codes:
+			0 = success
+			1 = failure
+			3 = update -strict is missing required keys
 	*/
 
 	return nil
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"time"
+
+	"github.com/StackAdapt/systags/manager"
+)
+
+type HistoryCommand struct {
+	baseCommand
+}
+
+func NewHistoryCommand() *HistoryCommand {
+
+	cmd := &HistoryCommand{
+		baseCommand: baseCommand{
+			flagSet: flag.NewFlagSet("", flag.ContinueOnError),
+		},
+	}
+
+	// Don't print unneeded usage
+	cmd.flagSet.Usage = func() {}
+
+	return cmd
+}
+
+func (cmd *HistoryCommand) Apply(m *manager.Manager) error {
+
+	err := m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	history, err := m.History()
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		Generation int                `json:"generation"`
+		ID         string             `json:"id"`
+		Time       time.Time          `json:"time"`
+		Remote     manager.TagChanges `json:"remote"`
+		System     manager.TagChanges `json:"system"`
+	}
+
+	// Each generation was replaced by the one before it
+	// in the list, and the newest by the current state
+	nextRemote := m.RemoteTags()
+	nextSystem := m.SystemTags()
+
+	entries := make([]entry, 0, len(history))
+	for i, gen := range history {
+
+		entries = append(entries, entry{
+			Generation: i + 1,
+			ID:         gen.ID,
+			Time:       gen.Time,
+			Remote:     manager.DiffTags(gen.Remote, nextRemote),
+			System:     manager.DiffTags(gen.System, nextSystem),
+		})
+
+		nextRemote = gen.Remote
+		nextSystem = gen.System
+	}
+
+	// Attempt to convert the history to JSON
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	m.GetLogger().Info(string(out))
+
+	return nil
+}
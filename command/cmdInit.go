@@ -1,6 +1,7 @@
 package command
 
 import (
+	"errors"
 	"flag"
 
 	"github.com/StackAdapt/systags/manager"
@@ -8,7 +9,11 @@ import (
 
 type InitCommand struct {
 	baseCommand
-	reset bool
+	reset    bool
+	fileMode string
+	dirMode  string
+	owner    string
+	group    string
 }
 
 func NewInitCommand() *InitCommand {
@@ -21,6 +26,14 @@ func NewInitCommand() *InitCommand {
 
 	cmd.flagSet.BoolVar(&cmd.reset, "r", false, "")
 	cmd.flagSet.BoolVar(&cmd.reset, "reset", false, "")
+	cmd.flagSet.StringVar(&cmd.fileMode, "m", "", "")
+	cmd.flagSet.StringVar(&cmd.fileMode, "mode", "", "")
+	cmd.flagSet.StringVar(&cmd.dirMode, "d", "", "")
+	cmd.flagSet.StringVar(&cmd.dirMode, "dir-mode", "", "")
+	cmd.flagSet.StringVar(&cmd.owner, "o", "", "")
+	cmd.flagSet.StringVar(&cmd.owner, "owner", "", "")
+	cmd.flagSet.StringVar(&cmd.group, "g", "", "")
+	cmd.flagSet.StringVar(&cmd.group, "group", "", "")
 
 	// Don't print unneeded usage
 	cmd.flagSet.Usage = func() {}
@@ -28,9 +41,75 @@ func NewInitCommand() *InitCommand {
 	return cmd
 }
 
+func (cmd *InitCommand) Parse(args []string) error {
+
+	err := cmd.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.fileMode != "" {
+		if _, err := manager.ParseMode(cmd.fileMode); err != nil {
+			return errors.New("flag has unsupported value: -mode")
+		}
+	}
+
+	if cmd.dirMode != "" {
+		if _, err := manager.ParseMode(cmd.dirMode); err != nil {
+			return errors.New("flag has unsupported value: -dir-mode")
+		}
+	}
+
+	return nil
+}
+
 func (cmd *InitCommand) Apply(m *manager.Manager) error {
 
-	err := m.LoadFiles()
+	if cmd.fileMode != "" {
+		m.FileMode, _ = manager.ParseMode(cmd.fileMode)
+	}
+
+	if cmd.dirMode != "" {
+		m.DirMode, _ = manager.ParseMode(cmd.dirMode)
+	}
+
+	if cmd.owner != "" {
+		uid, err := manager.LookupOwner(cmd.owner)
+		if err != nil {
+			return err
+		}
+
+		m.Owner = uid
+	}
+
+	if cmd.group != "" {
+		gid, err := manager.LookupGroup(cmd.group)
+		if err != nil {
+			return err
+		}
+
+		m.Group = gid
+	}
+
+	// The lock file lives in the SystemDir
+	err := m.CreateSystemDir()
+	if err != nil {
+		return err
+	}
+
+	// Keep other processes out until the files are saved
+	err = m.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := m.Unlock(); err != nil {
+			m.GetLogger().Warn(err.Error())
+		}
+	}()
+
+	err = m.LoadFiles()
 	if err != nil {
 		return err
 	}
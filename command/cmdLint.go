@@ -0,0 +1,71 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+
+	"github.com/StackAdapt/systags/manager"
+)
+
+type LintCommand struct {
+	baseCommand
+	strict bool
+}
+
+func NewLintCommand() *LintCommand {
+
+	cmd := &LintCommand{
+		baseCommand: baseCommand{
+			flagSet: flag.NewFlagSet("", flag.ContinueOnError),
+		},
+	}
+
+	cmd.flagSet.BoolVar(&cmd.strict, "s", false, "")
+	cmd.flagSet.BoolVar(&cmd.strict, "strict", false, "")
+
+	// Don't print unneeded usage
+	cmd.flagSet.Usage = func() {}
+
+	return cmd
+}
+
+func (cmd *LintCommand) Apply(m *manager.Manager) error {
+
+	strict := cmd.strict || m.StrictConfig
+
+	// Report the conflicts rather than failing early
+	m.StrictConfig = false
+
+	err := m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	conflicts := m.Conflicts()
+	if conflicts == nil {
+		conflicts = []manager.Conflict{}
+	}
+
+	// Attempt to convert the conflicts to JSON
+	out, err := json.MarshalIndent(conflicts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	m.GetLogger().Info(string(out))
+
+	templateErrs := m.TemplateErrors()
+	for _, err := range templateErrs {
+		m.GetLogger().Warn(err.Error())
+	}
+
+	if strict && len(conflicts) > 0 {
+		return &manager.ConflictError{Conflicts: conflicts}
+	}
+
+	if strict && len(templateErrs) > 0 {
+		return templateErrs[0]
+	}
+
+	return nil
+}
@@ -1,20 +1,25 @@
 package command
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"time"
 
 	"github.com/StackAdapt/systags/manager"
 )
 
 type LsCommand struct {
 	baseCommand
-	regex  bool
-	pick   string
-	omit   string
-	format string
-	prefix string
-	suffix string
+	regex   bool
+	pick    string
+	omit    string
+	format  string
+	prefix  string
+	suffix  string
+	maxAge  time.Duration
+	explain bool
 }
 
 func NewLsCommand() *LsCommand {
@@ -37,6 +42,10 @@ func NewLsCommand() *LsCommand {
 	cmd.flagSet.StringVar(&cmd.prefix, "prefix", "", "")
 	cmd.flagSet.StringVar(&cmd.suffix, "u", "", "")
 	cmd.flagSet.StringVar(&cmd.suffix, "suffix", "", "")
+	cmd.flagSet.DurationVar(&cmd.maxAge, "a", 0, "")
+	cmd.flagSet.DurationVar(&cmd.maxAge, "max-age", 0, "")
+	cmd.flagSet.BoolVar(&cmd.explain, "x", false, "")
+	cmd.flagSet.BoolVar(&cmd.explain, "explain", false, "")
 
 	// Don't print unneeded usage
 	cmd.flagSet.Usage = func() {}
@@ -61,6 +70,11 @@ func (cmd *LsCommand) Parse(args []string) error {
 		return errors.New("flag has unsupported value: -format")
 	}
 
+	// Provenance doesn't fit in the flat formats
+	if cmd.explain && cmd.format != "json" {
+		return errors.New("flag has unsupported value: -format")
+	}
+
 	return nil
 }
 
@@ -71,6 +85,23 @@ func (cmd *LsCommand) Apply(m *manager.Manager) error {
 		return err
 	}
 
+	// Refuse to serve stale remote tags
+	if cmd.maxAge > 0 {
+
+		age, found := m.RemoteAge()
+		if !found {
+			return errors.New("remote tags have never been fetched")
+		}
+
+		if age > cmd.maxAge {
+			return fmt.Errorf("remote tags are older than %s: %s", cmd.maxAge, age.Round(time.Second))
+		}
+	}
+
+	if cmd.explain {
+		return cmd.applyExplain(m)
+	}
+
 	tags := m.GetTags(cmd.regex, cmd.pick, cmd.omit)
 
 	// Append prefixes or suffixes to keys
@@ -90,3 +121,24 @@ func (cmd *LsCommand) Apply(m *manager.Manager) error {
 
 	return nil
 }
+
+// applyExplain outputs the provenance of every tag
+func (cmd *LsCommand) applyExplain(m *manager.Manager) error {
+
+	result := m.ExplainTags(cmd.regex, cmd.pick, cmd.omit)
+
+	// Keys are output the same way as without -explain
+	for i := range result {
+		result[i].Key = cmd.prefix + result[i].Key + cmd.suffix
+	}
+
+	// Attempt to convert the provenance to JSON
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	m.GetLogger().Info(string(out))
+
+	return nil
+}
@@ -45,12 +45,27 @@ func (cmd *RmCommand) Parse(args []string) error {
 
 func (cmd *RmCommand) Apply(m *manager.Manager) error {
 
-	err := m.LoadFiles()
+	// Keep other processes out until the files are saved
+	err := m.Lock()
 	if err != nil {
 		return err
 	}
 
-	m.RemoveTag(cmd.key)
+	defer func() {
+		if err := m.Unlock(); err != nil {
+			m.GetLogger().Warn(err.Error())
+		}
+	}()
+
+	err = m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	_, err = m.RemoveTag(cmd.key)
+	if err != nil {
+		return err
+	}
 
 	err = m.SaveFiles()
 	if err != nil {
@@ -0,0 +1,76 @@
+package command
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/StackAdapt/systags/manager"
+)
+
+type RollbackCommand struct {
+	baseCommand
+	to string
+}
+
+func NewRollbackCommand() *RollbackCommand {
+
+	cmd := &RollbackCommand{
+		baseCommand: baseCommand{
+			flagSet: flag.NewFlagSet("", flag.ContinueOnError),
+		},
+	}
+
+	cmd.flagSet.StringVar(&cmd.to, "t", "", "")
+	cmd.flagSet.StringVar(&cmd.to, "to", "", "")
+
+	// Don't print unneeded usage
+	cmd.flagSet.Usage = func() {}
+
+	return cmd
+}
+
+func (cmd *RollbackCommand) Parse(args []string) error {
+
+	err := cmd.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.to == "" {
+		return errors.New("flag needs to be provided: -to")
+	}
+
+	return nil
+}
+
+func (cmd *RollbackCommand) Apply(m *manager.Manager) error {
+
+	// Keep other processes out until the files are saved
+	err := m.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := m.Unlock(); err != nil {
+			m.GetLogger().Warn(err.Error())
+		}
+	}()
+
+	err = m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	err = m.Rollback(cmd.to)
+	if err != nil {
+		return err
+	}
+
+	err = m.SaveFiles()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
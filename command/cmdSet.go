@@ -52,12 +52,27 @@ func (cmd *SetCommand) Parse(args []string) error {
 
 func (cmd *SetCommand) Apply(m *manager.Manager) error {
 
-	err := m.LoadFiles()
+	// Keep other processes out until the files are saved
+	err := m.Lock()
 	if err != nil {
 		return err
 	}
 
-	m.SetTag(cmd.key, cmd.val)
+	defer func() {
+		if err := m.Unlock(); err != nil {
+			m.GetLogger().Warn(err.Error())
+		}
+	}()
+
+	err = m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	_, err = m.SetTag(cmd.key, cmd.val)
+	if err != nil {
+		return err
+	}
 
 	err = m.SaveFiles()
 	if err != nil {
@@ -0,0 +1,56 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"time"
+
+	"github.com/StackAdapt/systags/manager"
+)
+
+type StatusCommand struct {
+	baseCommand
+}
+
+func NewStatusCommand() *StatusCommand {
+
+	cmd := &StatusCommand{
+		baseCommand: baseCommand{
+			flagSet: flag.NewFlagSet("", flag.ContinueOnError),
+		},
+	}
+
+	// Don't print unneeded usage
+	cmd.flagSet.Usage = func() {}
+
+	return cmd
+}
+
+func (cmd *StatusCommand) Apply(m *manager.Manager) error {
+
+	err := m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	status := struct {
+		manager.RemoteStatus
+		Age string `json:"age,omitempty"`
+	}{
+		RemoteStatus: m.RemoteStatus(),
+	}
+
+	if age, found := m.RemoteAge(); found {
+		status.Age = age.Round(time.Second).String()
+	}
+
+	// Attempt to convert the status to JSON
+	out, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	m.GetLogger().Info(string(out))
+
+	return nil
+}
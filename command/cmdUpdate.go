@@ -1,6 +1,7 @@
 package command
 
 import (
+	"errors"
 	"flag"
 	"strings"
 	"time"
@@ -10,9 +11,16 @@ import (
 
 type UpdateCommand struct {
 	baseCommand
-	timeout time.Duration
-	retry   time.Duration
-	keys    string
+	timeout   time.Duration
+	retry     time.Duration
+	keys      string
+	provider  string
+	identity  bool
+	namespace string
+	imds      string
+	ec2       string
+	strict    bool
+	validate  bool
 }
 
 func NewUpdateCommand() *UpdateCommand {
@@ -29,6 +37,18 @@ func NewUpdateCommand() *UpdateCommand {
 	cmd.flagSet.DurationVar(&cmd.retry, "retry", 0*time.Second, "")
 	cmd.flagSet.StringVar(&cmd.keys, "k", "", "")
 	cmd.flagSet.StringVar(&cmd.keys, "keys", "", "")
+	cmd.flagSet.StringVar(&cmd.provider, "p", "", "")
+	cmd.flagSet.StringVar(&cmd.provider, "provider", "", "")
+	cmd.flagSet.BoolVar(&cmd.identity, "i", false, "")
+	cmd.flagSet.BoolVar(&cmd.identity, "identity", false, "")
+	cmd.flagSet.StringVar(&cmd.namespace, "n", "", "")
+	cmd.flagSet.StringVar(&cmd.namespace, "namespace", "", "")
+	cmd.flagSet.StringVar(&cmd.imds, "imds-endpoint", "", "")
+	cmd.flagSet.StringVar(&cmd.ec2, "ec2-endpoint", "", "")
+	cmd.flagSet.BoolVar(&cmd.strict, "s", false, "")
+	cmd.flagSet.BoolVar(&cmd.strict, "strict", false, "")
+	cmd.flagSet.BoolVar(&cmd.validate, "v", false, "")
+	cmd.flagSet.BoolVar(&cmd.validate, "validate", false, "")
 
 	// Don't print unneeded usage
 	cmd.flagSet.Usage = func() {}
@@ -36,20 +56,93 @@ func NewUpdateCommand() *UpdateCommand {
 	return cmd
 }
 
-func (cmd *UpdateCommand) Apply(m *manager.Manager) error {
+func (cmd *UpdateCommand) Parse(args []string) error {
 
-	err := m.LoadFiles()
+	err := cmd.flagSet.Parse(args)
 	if err != nil {
 		return err
 	}
 
+	// Every listed provider must be registered
+	if cmd.provider != "" {
+		for _, name := range strings.Split(cmd.provider, ",") {
+
+			name = strings.TrimSpace(name)
+
+			_, found := manager.Providers[name]
+			if !found && name != "auto" {
+				return errors.New("flag has unsupported value: -provider")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (cmd *UpdateCommand) Apply(m *manager.Manager) error {
+
+	if cmd.provider != "" {
+		m.Provider = cmd.provider
+	}
+
+	if cmd.identity {
+		m.AwsIdentity = true
+	}
+
+	if cmd.namespace != "" {
+		m.AwsNamespace = cmd.namespace
+	}
+
+	if cmd.imds != "" {
+		m.AwsImdsEndpoint = cmd.imds
+	}
+
+	if cmd.ec2 != "" {
+		m.AwsEc2Endpoint = cmd.ec2
+	}
+
+	if cmd.strict {
+		m.StrictRemote = true
+	}
+
+	if cmd.validate {
+		m.ValidateRemote = true
+	}
+
 	var keys []string
 	if cmd.keys != "" {
 		keys = strings.Split(cmd.keys, ",")
 	}
 
-	err = m.UpdateRemote(cmd.timeout, cmd.retry, keys)
+	// Don't hold the lock while waiting on the network
+	fetch := m.FetchRemote(cmd.timeout, cmd.retry, keys)
+
+	// Keep other processes out until the files are saved
+	err := m.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := m.Unlock(); err != nil {
+			m.GetLogger().Warn(err.Error())
+		}
+	}()
+
+	err = m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	err = m.ApplyRemote(fetch)
 	if err != nil {
+
+		// Record the failure but keep serving the
+		// last-known-good remote tags from the file
+		if err := m.SaveStatus(); err != nil {
+			m.GetLogger().Warn(err.Error())
+		}
+
 		return err
 	}
 
@@ -58,5 +151,10 @@ func (cmd *UpdateCommand) Apply(m *manager.Manager) error {
 		return err
 	}
 
+	err = m.SaveStatus()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,53 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+
+	"github.com/StackAdapt/systags/manager"
+)
+
+type ValidateCommand struct {
+	baseCommand
+}
+
+func NewValidateCommand() *ValidateCommand {
+
+	cmd := &ValidateCommand{
+		baseCommand: baseCommand{
+			flagSet: flag.NewFlagSet("", flag.ContinueOnError),
+		},
+	}
+
+	// Don't print unneeded usage
+	cmd.flagSet.Usage = func() {}
+
+	return cmd
+}
+
+func (cmd *ValidateCommand) Apply(m *manager.Manager) error {
+
+	err := m.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	violations := m.Validate()
+	if violations == nil {
+		violations = []manager.SchemaViolation{}
+	}
+
+	// Attempt to convert the violations to JSON
+	out, err := json.MarshalIndent(violations, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	m.GetLogger().Info(string(out))
+
+	if len(violations) > 0 {
+		return &manager.SchemaError{Violations: violations}
+	}
+
+	return nil
+}
@@ -5,15 +5,22 @@ import (
 )
 
 var Commands = map[string]Command{
-	"help":    NewHelpCommand(),
-	"init":    NewInitCommand(),
-	"dump":    NewDumpCommand(),
-	"update":  NewUpdateCommand(),
-	"ls":      NewLsCommand(),
-	"get":     NewGetCommand(),
-	"set":     NewSetCommand(),
-	"rm":      NewRmCommand(),
-	"version": NewVersionCommand(),
+	"help":     NewHelpCommand(),
+	"init":     NewInitCommand(),
+	"dump":     NewDumpCommand(),
+	"update":   NewUpdateCommand(),
+	"ls":       NewLsCommand(),
+	"get":      NewGetCommand(),
+	"set":      NewSetCommand(),
+	"rm":       NewRmCommand(),
+	"status":   NewStatusCommand(),
+	"history":  NewHistoryCommand(),
+	"rollback": NewRollbackCommand(),
+	"audit":    NewAuditCommand(),
+	"explain":  NewExplainCommand(),
+	"lint":     NewLintCommand(),
+	"validate": NewValidateCommand(),
+	"version":  NewVersionCommand(),
 }
 
 func ParseArgs(m *manager.Manager, args []string) error {
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/StackAdapt/systags/command"
 	"github.com/StackAdapt/systags/manager"
 	"github.com/StackAdapt/systags/utility"
 )
 
+// Exit code when update -strict is missing required keys
+const exitMissingKeys = 3
+
 func main() {
 
 	m := manager.NewManager()
@@ -34,8 +41,182 @@ func main() {
 		m.SystemDir = systemDir
 	}
 
+	if os.Getenv("SYSTAGS_CONFIG_RECURSIVE") != "" {
+		m.ConfigRecursive = true
+	}
+
+	lockTimeout := os.Getenv("SYSTAGS_LOCK_TIMEOUT")
+
+	if lockTimeout != "" {
+		timeout, err := time.ParseDuration(lockTimeout)
+		if err != nil {
+			logger.Error("invalid SYSTAGS_LOCK_TIMEOUT: " + err.Error())
+			os.Exit(1)
+		}
+
+		m.LockTimeout = timeout
+	}
+
+	historyLimit := os.Getenv("SYSTAGS_HISTORY_LIMIT")
+
+	if historyLimit != "" {
+		limit, err := strconv.Atoi(historyLimit)
+		if err != nil {
+			logger.Error("invalid SYSTAGS_HISTORY_LIMIT: " + err.Error())
+			os.Exit(1)
+		}
+
+		m.HistoryLimit = limit
+	}
+
+	fileMode := os.Getenv("SYSTAGS_FILE_MODE")
+	dirMode := os.Getenv("SYSTAGS_DIR_MODE")
+
+	if fileMode != "" {
+		mode, err := manager.ParseMode(fileMode)
+		if err != nil {
+			logger.Error("invalid SYSTAGS_FILE_MODE: " + err.Error())
+			os.Exit(1)
+		}
+
+		m.FileMode = mode
+	}
+
+	if dirMode != "" {
+		mode, err := manager.ParseMode(dirMode)
+		if err != nil {
+			logger.Error("invalid SYSTAGS_DIR_MODE: " + err.Error())
+			os.Exit(1)
+		}
+
+		m.DirMode = mode
+	}
+
+	owner := os.Getenv("SYSTAGS_OWNER")
+	group := os.Getenv("SYSTAGS_GROUP")
+
+	if owner != "" {
+		uid, err := manager.LookupOwner(owner)
+		if err != nil {
+			logger.Error("invalid SYSTAGS_OWNER: " + err.Error())
+			os.Exit(1)
+		}
+
+		m.Owner = uid
+	}
+
+	if group != "" {
+		gid, err := manager.LookupGroup(group)
+		if err != nil {
+			logger.Error("invalid SYSTAGS_GROUP: " + err.Error())
+			os.Exit(1)
+		}
+
+		m.Group = gid
+	}
+
+	if os.Getenv("SYSTAGS_STRICT") != "" {
+		m.StrictConfig = true
+	}
+
+	if os.Getenv("SYSTAGS_AUDIT_CHAIN") != "" {
+		m.AuditChain = true
+	}
+
+	provider := os.Getenv("SYSTAGS_PROVIDER")
+	awsImdsEndpoint := os.Getenv("SYSTAGS_AWS_IMDS_ENDPOINT")
+	awsEc2Endpoint := os.Getenv("SYSTAGS_AWS_EC2_ENDPOINT")
+	gcpHost := os.Getenv("GCE_METADATA_HOST")
+	gcpComputeEndpoint := os.Getenv("SYSTAGS_GCP_COMPUTE_ENDPOINT")
+	gcpAttributes := os.Getenv("SYSTAGS_GCP_ATTRIBUTES")
+	azureEndpoint := os.Getenv("SYSTAGS_AZURE_ENDPOINT")
+
+	if provider != "" {
+		m.Provider = provider
+	}
+
+	if awsImdsEndpoint != "" {
+		m.AwsImdsEndpoint = awsImdsEndpoint
+	}
+
+	if awsEc2Endpoint != "" {
+		m.AwsEc2Endpoint = awsEc2Endpoint
+	}
+
+	if gcpHost != "" {
+		m.GcpEndpoint = "http://" + gcpHost + "/computeMetadata/v1"
+	}
+
+	if gcpComputeEndpoint != "" {
+		m.GcpComputeEndpoint = gcpComputeEndpoint
+	}
+
+	if gcpAttributes != "" {
+		m.GcpAttributes = strings.Split(gcpAttributes, ",")
+	}
+
+	if azureEndpoint != "" {
+		m.AzureEndpoint = azureEndpoint
+	}
+
+	kubeConfig := os.Getenv("SYSTAGS_KUBECONFIG")
+	kubeNode := os.Getenv("SYSTAGS_KUBE_NODE")
+	kubePrefix := os.Getenv("SYSTAGS_KUBE_PREFIX")
+	kubeAnnotations := os.Getenv("SYSTAGS_KUBE_ANNOTATIONS")
+
+	if kubeConfig != "" {
+		m.KubeConfig = kubeConfig
+	}
+
+	if kubeNode != "" {
+		m.KubeNode = kubeNode
+	} else {
+		// Commonly set through the downward API
+		m.KubeNode = os.Getenv("NODE_NAME")
+	}
+
+	if kubePrefix != "" {
+		m.KubePrefix = kubePrefix
+	}
+
+	if kubeAnnotations != "" {
+		m.KubeAnnotations = strings.Split(kubeAnnotations, ",")
+	}
+
+	execDir := os.Getenv("SYSTAGS_EXEC_DIR")
+
+	if execDir != "" {
+		m.ExecDir = execDir
+	}
+
+	store := os.Getenv("SYSTAGS_STORE")
+
+	if store != "" {
+
+		// Every command runs in its own process
+		if store == "memory" {
+			logger.Error("invalid SYSTAGS_STORE: memory store can't persist tags")
+			os.Exit(1)
+		}
+
+		newStore, found := manager.Stores[store]
+		if !found {
+			logger.Error("invalid SYSTAGS_STORE: unknown store: " + store)
+			os.Exit(1)
+		}
+
+		m.Store = newStore(m)
+	}
+
 	// Perform CLI parsing, errors are logged using logger
 	if err := command.ParseArgs(m, os.Args); err != nil {
+
+		// Let boot scripts branch on missing required keys
+		var missing *manager.MissingKeysError
+		if errors.As(err, &missing) {
+			os.Exit(exitMissingKeys)
+		}
+
 		os.Exit(1)
 	}
 }
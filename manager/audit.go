@@ -0,0 +1,317 @@
+package manager
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AuditEntry describes a single change to a tag, as
+// recorded in the audit journal in the SystemDir.
+type AuditEntry struct {
+	// When the change was made
+	Time time.Time `json:"time"`
+
+	// What made the change, such as set or update
+	Action string `json:"action"`
+
+	// The layer holding the tag, remote or system
+	Layer string `json:"layer"`
+
+	// The key of the changed tag
+	Key string `json:"key"`
+
+	// The previous value, nil if it didn't exist
+	Old *string `json:"old"`
+
+	// The new value, nil if it was removed
+	New *string `json:"new"`
+
+	// Who made the change
+	UID      int    `json:"uid"`
+	SudoUser string `json:"sudo_user,omitempty"`
+
+	// The command line of the process
+	Command string `json:"command"`
+
+	// Hash of the previous entry when chained
+	Prev string `json:"prev,omitempty"`
+
+	// Hash of this entry, including Prev
+	Hash string `json:"hash,omitempty"`
+}
+
+// auditFile returns the path of the audit journal
+func (m *Manager) auditFile() string {
+	return filepath.Join(m.SystemDir, "audit.jsonl")
+}
+
+// recordAudit queues a change for the audit journal,
+// which is written out by SaveFiles
+func (m *Manager) recordAudit(action string, layer string, key string, old *string, new *string) {
+
+	// Skip changes which aren't changes
+	if old != nil && new != nil && *old == *new {
+		return
+	}
+
+	m.audit = append(m.audit, AuditEntry{
+		Time:     time.Now().UTC(),
+		Action:   action,
+		Layer:    layer,
+		Key:      key,
+		Old:      old,
+		New:      new,
+		UID:      os.Getuid(),
+		SudoUser: os.Getenv("SUDO_USER"),
+		Command:  strings.Join(os.Args, " "),
+	})
+}
+
+// recordAuditDiff queues every difference between the
+// old and new tags of a layer for the audit journal
+func (m *Manager) recordAuditDiff(action string, layer string, old Tags, new Tags) {
+
+	changes := DiffTags(old, new)
+
+	keys := append(append(changes.Added, changes.Removed...), changes.Modified...)
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		m.recordAudit(action, layer, key, lookupTag(old, key), lookupTag(new, key))
+	}
+}
+
+// lookupTag returns a pointer to the tag value or nil
+func lookupTag(tags Tags, key string) *string {
+
+	if value, found := tags[key]; found {
+		return &value
+	}
+
+	return nil
+}
+
+// hashAuditEntry returns the chain hash of an entry,
+// which covers every field apart from the hash itself
+func hashAuditEntry(entry AuditEntry) (string, error) {
+
+	entry.Hash = ""
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastAuditHash returns the hash of the last entry of
+// the journal, or an empty string if there is none
+func lastAuditHash(path string) (string, error) {
+
+	entries, err := readAudit(path)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	return entries[len(entries)-1].Hash, nil
+}
+
+// flushAudit appends the queued changes to the journal,
+// chaining their hashes when AuditChain is enabled
+func (m *Manager) flushAudit() error {
+
+	if len(m.audit) == 0 {
+		return nil
+	}
+
+	path := m.auditFile()
+
+	prev := ""
+	if m.AuditChain {
+
+		var err error
+		prev, err = lastAuditHash(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var data []byte
+	for _, entry := range m.audit {
+
+		if m.AuditChain {
+
+			entry.Prev = prev
+
+			hash, err := hashAuditEntry(entry)
+			if err != nil {
+				return err
+			}
+
+			entry.Hash = hash
+			prev = hash
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		data = append(append(data, line...), '\n')
+	}
+
+	m.GetLogger().Debug("appending audit journal: " + path)
+
+	file, err := m.openFile(path, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	m.audit = nil
+	return file.Close()
+}
+
+// readAudit parses every entry of the journal at path
+func readAudit(path string) ([]AuditEntry, error) {
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	var entries []AuditEntry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+
+		var entry AuditEntry
+		// Try and parse the line as an entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Audit returns the entries of the audit journal which
+// match the key, unless it's empty, and fall within the
+// time range, where zero times leave it unbounded.
+func (m *Manager) Audit(key string, since time.Time, until time.Time) ([]AuditEntry, error) {
+
+	entries, err := readAudit(m.auditFile())
+	if err != nil {
+		return nil, err
+	}
+
+	var result []AuditEntry
+	for _, entry := range entries {
+
+		if key != "" && entry.Key != key {
+			continue
+		}
+
+		if !since.IsZero() && entry.Time.Before(since) {
+			continue
+		}
+
+		if !until.IsZero() && entry.Time.After(until) {
+			continue
+		}
+
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+// VerifyAudit checks the hash chain of the audit journal
+// and returns an error naming the first entry which was
+// tampered with. Entries written before chaining was
+// enabled are skipped, but once the chain has started
+// every entry must be chained to the one before it. It
+// returns the number of chained entries.
+func (m *Manager) VerifyAudit() (int, error) {
+
+	entries, err := readAudit(m.auditFile())
+	if err != nil {
+		return 0, err
+	}
+
+	chained := 0
+
+	for i, entry := range entries {
+
+		// Entries written before chaining was enabled
+		if entry.Hash == "" {
+			if chained > 0 {
+				return chained, fmt.Errorf("audit entry %d is not chained", i+1)
+			}
+			continue
+		}
+
+		hash, err := hashAuditEntry(entry)
+		if err != nil {
+			return chained, err
+		}
+
+		if hash != entry.Hash {
+			return chained, fmt.Errorf("audit entry %d was modified", i+1)
+		}
+
+		// Only the first chained entry has nothing to follow
+		prev := ""
+		if i > 0 {
+			prev = entries[i-1].Hash
+		}
+
+		if entry.Prev != prev {
+			return chained, fmt.Errorf("audit entry %d does not follow the previous entry", i+1)
+		}
+
+		chained++
+	}
+
+	return chained, nil
+}
@@ -2,10 +2,13 @@ package manager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -13,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 )
 
 func getAwsMetadata(
@@ -48,24 +52,120 @@ func getAwsMetadata(
 	return string(value), nil
 }
 
-func getAwsTags(logger *slog.Logger, timeout time.Duration) (Tags, error) {
+// Maximum number of concurrent IMDS tag requests
+const awsMetadataWorkers = 8
 
-	logger.Debug("getting aws tags")
+// getAwsMetadataTags retrieves the values of the
+// instance tags with a bounded pool of workers which
+// all share the deadline of the context
+func getAwsMetadataTags(
+	client *imds.Client,
+	ctx context.Context,
+	keys []string,
+) (Tags, error) {
 
-	// Load a default AWS configuration
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	values := make([]string, len(keys))
+	errs := make([]error, len(keys))
+
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < awsMetadataWorkers && w < len(keys); w++ {
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				// Retrieve value for the key
+				values[i], errs[i] = getAwsMetadata(
+					client, ctx, fmt.Sprintf("tags/instance/%s", keys[i]),
+				)
+			}
+		}()
+	}
+
+	for i := range keys {
+		indices <- i
+	}
+
+	close(indices)
+	wg.Wait()
+
+	var firstErr error
+
+	output := make(Tags)
+	for i, key := range keys {
+
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+
+			continue
+		}
+
+		output[key] = values[i]
+	}
+
+	if firstErr != nil {
+
+		// Whatever was retrieved is incomplete
+		if len(output) > 0 {
+			return nil, &PartialTagsError{Tags: output, Err: firstErr}
+		}
+
+		return nil, firstErr
+	}
+
+	return output, nil
+}
+
+func getAwsIdentity(
+	client *imds.Client,
+	ctx context.Context,
+	namespace string,
+) (Tags, error) {
+
+	// Retrieve the instance identity document
+	document, err := client.GetInstanceIdentityDocument(
+		ctx, &imds.GetInstanceIdentityDocumentInput{},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	// Don't wait too long for API call
-	ctx, cancel := context.WithTimeout(
-		context.Background(), timeout,
-	)
-	defer cancel()
+	zone, err := getAwsMetadata(client, ctx, "placement/availability-zone")
+	if err != nil {
+		return nil, err
+	}
 
-	// Create new IMDS client from config
-	imdsClient := imds.NewFromConfig(cfg)
+	// Either spot, on-demand, or scheduled
+	lifecycle, err := getAwsMetadata(client, ctx, "instance-life-cycle")
+	if err != nil {
+		return nil, err
+	}
+
+	output := Tags{
+		namespace + "region":             document.Region,
+		namespace + "availability-zone":  zone,
+		namespace + "instance-type":      document.InstanceType,
+		namespace + "ami-id":             document.ImageID,
+		namespace + "account-id":         document.AccountID,
+		namespace + "instance-lifecycle": lifecycle,
+	}
+
+	return output, nil
+}
+
+func getAwsTags(
+	logger *slog.Logger,
+	ctx context.Context,
+	imdsClient *imds.Client,
+	ec2Client *ec2.Client,
+) (Tags, error) {
+
+	logger.Debug("getting aws tags")
 
 	// Check if the tags can be retrieved using instance metadata
 	tags, err := getAwsMetadata(imdsClient, ctx, "tags/instance")
@@ -73,23 +173,11 @@ func getAwsTags(logger *slog.Logger, timeout time.Duration) (Tags, error) {
 
 		logger.Debug("using instance metadata")
 
-		// Convert the tags into a slice
-		keys := strings.Split(tags, "\n")
-
-		output := make(Tags)
-		// Loop through tag keys
-		for _, key := range keys {
-
-			// Retrieve value for the key
-			value, err := getAwsMetadata(imdsClient, ctx, fmt.Sprintf("tags/instance/%s", key))
-			if err != nil {
-				return nil, err
-			}
-
-			output[key] = value
-		}
+		// Convert the tags into a slice, keys exposed in
+		// instance metadata can't contain any whitespace
+		keys := strings.Fields(tags)
 
-		return output, nil
+		return getAwsMetadataTags(imdsClient, ctx, keys)
 
 	} else {
 
@@ -101,9 +189,6 @@ func getAwsTags(logger *slog.Logger, timeout time.Duration) (Tags, error) {
 			return nil, err
 		}
 
-		// Create new EC2 client from config
-		ec2Client := ec2.NewFromConfig(cfg)
-
 		// Select current instance
 		filters := []types.Filter{
 			{
@@ -116,26 +201,235 @@ func getAwsTags(logger *slog.Logger, timeout time.Duration) (Tags, error) {
 
 		// Set up the DescribeTags input
 		input := &ec2.DescribeTagsInput{
+			Filters: filters,
+		}
+
+		paginator := ec2.NewDescribeTagsPaginator(
+			ec2Client, input, func(o *ec2.DescribeTagsPaginatorOptions) {
+				o.Limit = 1000
+				o.StopOnDuplicateToken = true
+			},
+		)
+
+		output := make(Tags)
+		for paginator.HasMorePages() {
+
+			// Attempt to call DescribeTags
+			result, err := nextAwsTagsPage(logger, ctx, paginator)
+			if err != nil {
 
-			// Do not bother with NextToken because the
-			// maximum number of tags per resource is 50
+				// Whatever was retrieved so far is incomplete
+				if len(output) > 0 {
+					return nil, &PartialTagsError{Tags: output, Err: err}
+				}
 
-			Filters:    filters,
-			MaxResults: aws.Int32(1000),
+				return nil, err
+			}
+
+			// Convert result into tags slice
+			for _, tag := range result.Tags {
+				output[*tag.Key] = *tag.Value
+			}
 		}
 
-		// Attempt to call DescribeTags
-		result, err := ec2Client.DescribeTags(ctx, input)
-		if err != nil {
+		return output, nil
+	}
+}
+
+// isAwsThrottle reports whether err was caused by
+// exceeding the EC2 API request rate limits
+func isAwsThrottle(err error) bool {
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "RequestLimitExceeded", "Throttling", "ThrottlingException":
+		return true
+	}
+
+	return false
+}
+
+// awsRetryer keeps the retry settings of the EC2 client,
+// such as AWS_MAX_ATTEMPTS, but leaves throttling errors
+// to nextAwsTagsPage so they aren't retried twice over
+type awsRetryer struct {
+	aws.RetryerV2
+}
+
+func (r awsRetryer) IsErrorRetryable(err error) bool {
+	return !isAwsThrottle(err) && r.RetryerV2.IsErrorRetryable(err)
+}
+
+// nextAwsTagsPage retrieves the next DescribeTags page,
+// retrying with jittered exponential backoff while it's
+// being throttled and the context deadline allows it
+func nextAwsTagsPage(
+	logger *slog.Logger,
+	ctx context.Context,
+	paginator *ec2.DescribeTagsPaginator,
+) (*ec2.DescribeTagsOutput, error) {
+
+	// Sleep duration to start with
+	curInterval := 200 * time.Millisecond
+
+	// Maximum duration to sleep for
+	maxInterval := 5 * time.Second
+
+	for {
+		result, err := paginator.NextPage(ctx)
+		if err == nil || !isAwsThrottle(err) {
+			return result, err
+		}
+
+		// Full jitter to spread out concurrent hosts
+		interval := time.Duration(rand.Int63n(int64(curInterval)) + 1)
+
+		// Give up if the sleep would exceed the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < interval {
 			return nil, err
 		}
 
-		output := make(Tags)
-		// Convert result into tags slice
-		for _, tag := range result.Tags {
-			output[*tag.Key] = *tag.Value
+		logger.Debug("describe tags throttled, retrying in " + interval.String())
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(interval):
+		}
+
+		// Adjust sleep duration to take longer the next time
+		curInterval *= 2
+		if curInterval > maxInterval {
+			curInterval = maxInterval
+		}
+	}
+}
+
+type awsProvider struct {
+	logger       *slog.Logger
+	identity     bool
+	namespace    string
+	imdsEndpoint string
+	ec2Endpoint  string
+}
+
+// NewAwsProvider creates a Provider which retrieves
+// the tags of the current EC2 instance.
+func NewAwsProvider(m *Manager) Provider {
+
+	return &awsProvider{
+		logger:       m.GetLogger(),
+		identity:     m.AwsIdentity,
+		namespace:    m.AwsNamespace,
+		imdsEndpoint: m.AwsImdsEndpoint,
+		ec2Endpoint:  m.AwsEc2Endpoint,
+	}
+}
+
+// newClients creates the IMDS and EC2 clients, taking
+// the endpoint overrides into account. The region is
+// looked up from IMDS when it isn't configured.
+func (p *awsProvider) newClients(ctx context.Context) (*imds.Client, *ec2.Client, error) {
+
+	var opts []func(*config.LoadOptions) error
+
+	if p.imdsEndpoint != "" {
+		opts = append(opts, config.WithEC2IMDSEndpoint(p.imdsEndpoint))
+	}
+
+	opts = append(opts, config.WithEC2IMDSRegion(
+		func(o *config.UseEC2IMDSRegion) {
+			o.Client = imds.New(imds.Options{Endpoint: p.imdsEndpoint})
+		},
+	))
+
+	// Load a default AWS configuration
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Create new IMDS client from config
+	imdsClient := imds.NewFromConfig(cfg)
+
+	// Create new EC2 client from config
+	ec2Client := ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+		if p.ec2Endpoint != "" {
+			o.EndpointResolver = ec2.EndpointResolverFromURL(p.ec2Endpoint)
+		}
+
+		// Throttling is retried by nextAwsTagsPage only, the
+		// retryer is already resolved from the loaded config
+		if retryer, ok := o.Retryer.(aws.RetryerV2); ok {
+			o.Retryer = awsRetryer{RetryerV2: retryer}
 		}
+	})
+
+	return imdsClient, ec2Client, nil
+}
+
+func (p *awsProvider) Name() string {
+	return "aws"
+}
+
+func (p *awsProvider) detectDmi() bool {
+
+	// Nitro instances report Amazon EC2 as the vendor
+	// while older Xen instances have an amazon BIOS
+	return readDmi("sys_vendor") == "Amazon EC2" ||
+		strings.Contains(readDmi("bios_version"), "amazon")
+}
+
+func (p *awsProvider) Detect(ctx context.Context) bool {
+
+	if p.detectDmi() {
+		return true
+	}
+
+	// Fall back to probing the metadata service
+	client := imds.New(imds.Options{Endpoint: p.imdsEndpoint})
+
+	_, err := getAwsMetadata(client, ctx, "instance-id")
+	return err == nil
+}
+
+func (p *awsProvider) Fetch(ctx context.Context) (Tags, error) {
 
+	imdsClient, ec2Client, err := p.newClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := getAwsTags(p.logger, ctx, imdsClient, ec2Client)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.identity {
 		return output, nil
 	}
+
+	p.logger.Debug("getting aws identity")
+
+	identity, err := getAwsIdentity(imdsClient, ctx, p.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Real tags always take precedence
+	for key, value := range identity {
+
+		if _, found := output[key]; found {
+			p.logger.Warn("identity key collides with tag: " + key)
+			continue
+		}
+
+		output[key] = value
+	}
+
+	return output, nil
 }
@@ -0,0 +1,600 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAws serves just enough of IMDS and the EC2 API
+// for the aws provider to fetch the tags of an instance
+type fakeAws struct {
+	mu sync.Mutex
+
+	// The tags of the instance
+	tags Tags
+
+	// Tags which only show up from the second listing
+	late Tags
+
+	// Whether tags are exposed in instance metadata
+	metadataTags bool
+
+	// Keys whose metadata value can't be retrieved
+	broken map[string]bool
+
+	// Tags per DescribeTags page
+	pageSize int
+
+	// DescribeTags requests to throttle first
+	throttles int
+
+	// DescribeTags requests to fail first, after throttling
+	failures int
+
+	// How long every request takes to be served
+	latency time.Duration
+
+	// Number of requests by metadata path or API action
+	requests map[string]int
+}
+
+const fakeAwsInstanceID = "i-0123456789abcdef0"
+
+func (f *fakeAws) count(name string) int {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests[name]
+}
+
+// visibleTags returns the tags the instance has after
+// the given number of listings
+func (f *fakeAws) visibleTags(listings int) Tags {
+
+	output := make(Tags)
+	for key, value := range f.tags {
+		output[key] = value
+	}
+
+	if listings > 1 {
+		for key, value := range f.late {
+			output[key] = value
+		}
+	}
+
+	return output
+}
+
+func (f *fakeAws) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	// Requests are slow concurrently, like the real ones
+	time.Sleep(f.latency)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.requests == nil {
+		f.requests = make(map[string]int)
+	}
+
+	if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+		w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+		_, _ = io.WriteString(w, "token")
+		return
+	}
+
+	if r.URL.Path == "/latest/dynamic/instance-identity/document" {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"instanceId":   fakeAwsInstanceID,
+			"region":       "us-east-1",
+			"instanceType": "t3.micro",
+			"imageId":      "ami-0123456789abcdef0",
+			"accountId":    "123456789012",
+		})
+		return
+	}
+
+	if path, found := strings.CutPrefix(r.URL.Path, "/latest/meta-data/"); found {
+
+		f.requests[path]++
+		f.serveMetadata(w, path)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == "/" {
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		action := r.Form.Get("Action")
+		f.requests[action]++
+
+		if action != "DescribeTags" {
+			http.Error(w, "unsupported action: "+action, http.StatusBadRequest)
+			return
+		}
+
+		f.serveDescribeTags(w, r)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func (f *fakeAws) serveMetadata(w http.ResponseWriter, path string) {
+
+	switch path {
+	case "instance-id":
+		_, _ = io.WriteString(w, fakeAwsInstanceID)
+		return
+
+	case "placement/availability-zone":
+		_, _ = io.WriteString(w, "us-east-1a")
+		return
+
+	case "instance-life-cycle":
+		_, _ = io.WriteString(w, "on-demand")
+		return
+	}
+
+	if !f.metadataTags {
+		http.NotFound(w, nil)
+		return
+	}
+
+	tags := f.visibleTags(f.requests["tags/instance"])
+
+	if path == "tags/instance" {
+
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		_, _ = io.WriteString(w, strings.Join(keys, "\n"))
+		return
+	}
+
+	key, _ := strings.CutPrefix(path, "tags/instance/")
+
+	value, found := tags[key]
+	if !found || f.broken[key] {
+		http.NotFound(w, nil)
+		return
+	}
+
+	_, _ = io.WriteString(w, value)
+}
+
+type fakeAwsTag struct {
+	ResourceID   string `xml:"resourceId"`
+	ResourceType string `xml:"resourceType"`
+	Key          string `xml:"key"`
+	Value        string `xml:"value"`
+}
+
+type fakeAwsTagsResponse struct {
+	XMLName   xml.Name     `xml:"DescribeTagsResponse"`
+	RequestID string       `xml:"requestId"`
+	Tags      []fakeAwsTag `xml:"tagSet>item"`
+	NextToken string       `xml:"nextToken,omitempty"`
+}
+
+type fakeAwsErrorResponse struct {
+	XMLName   xml.Name `xml:"Response"`
+	Code      string   `xml:"Errors>Error>Code"`
+	Message   string   `xml:"Errors>Error>Message"`
+	RequestID string   `xml:"RequestID"`
+}
+
+func (f *fakeAws) serveDescribeTags(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/xml")
+
+	if f.throttles > 0 {
+		f.throttles--
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = xml.NewEncoder(w).Encode(fakeAwsErrorResponse{
+			Code:      "RequestLimitExceeded",
+			Message:   "Request limit exceeded.",
+			RequestID: "fake",
+		})
+		return
+	}
+
+	if f.failures > 0 {
+		f.failures--
+
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = xml.NewEncoder(w).Encode(fakeAwsErrorResponse{
+			Code:      "InternalError",
+			Message:   "An internal error has occurred.",
+			RequestID: "fake",
+		})
+		return
+	}
+
+	if r.Form.Get("Filter.1.Value.1") != fakeAwsInstanceID {
+		http.Error(w, "unexpected filter", http.StatusBadRequest)
+		return
+	}
+
+	tags := f.visibleTags(f.requests["DescribeTags"])
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(r.Form.Get("NextToken"))
+
+	end := len(keys)
+	if f.pageSize > 0 && start+f.pageSize < end {
+		end = start + f.pageSize
+	}
+
+	response := fakeAwsTagsResponse{RequestID: "fake"}
+	for _, key := range keys[start:end] {
+		response.Tags = append(response.Tags, fakeAwsTag{
+			ResourceID:   fakeAwsInstanceID,
+			ResourceType: "instance",
+			Key:          key,
+			Value:        tags[key],
+		})
+	}
+
+	if end < len(keys) {
+		response.NextToken = strconv.Itoa(end)
+	}
+
+	_ = xml.NewEncoder(w).Encode(response)
+}
+
+// newAwsTestManager creates a Manager whose aws provider
+// talks to f, with the AWS environment isolated from the
+// host's, and its log written to the returned buffer
+func newAwsTestManager(tb testing.TB, f *fakeAws) (*Manager, *bytes.Buffer) {
+
+	server := httptest.NewServer(f)
+	tb.Cleanup(server.Close)
+
+	dir := tb.TempDir()
+
+	tb.Setenv("AWS_ACCESS_KEY_ID", "fake")
+	tb.Setenv("AWS_SECRET_ACCESS_KEY", "fake")
+	tb.Setenv("AWS_SESSION_TOKEN", "")
+	tb.Setenv("AWS_PROFILE", "")
+	tb.Setenv("AWS_REGION", "")
+	tb.Setenv("AWS_DEFAULT_REGION", "")
+	tb.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	tb.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	tb.Setenv("AWS_EC2_METADATA_DISABLED", "")
+
+	m := NewManager()
+	m.ConfigDir = filepath.Join(dir, "config.d")
+	m.SystemDir = filepath.Join(dir, "system")
+	m.Provider = "aws"
+	m.AwsImdsEndpoint = server.URL
+	m.AwsEc2Endpoint = server.URL
+
+	var logs bytes.Buffer
+	m.SetLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+
+	if err := m.LoadFiles(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return m, &logs
+}
+
+func assertTags(t *testing.T, got Tags, want Tags) {
+
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("got %d tags, want %d: %v", len(got), len(want), got)
+	}
+
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("tag %s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestUpdateRemoteAwsMetadataTags(t *testing.T) {
+
+	f := &fakeAws{
+		tags:         Tags{"Name": "web-1", "env": "prod", "team": "ads"},
+		metadataTags: true,
+	}
+
+	m, _ := newAwsTestManager(t, f)
+
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, m.RemoteTags(), f.tags)
+
+	if n := f.count("instance-id"); n != 0 {
+		t.Errorf("instance-id was requested %d times, want 0", n)
+	}
+
+	if n := f.count("DescribeTags"); n != 0 {
+		t.Errorf("DescribeTags was called %d times, want 0", n)
+	}
+
+	if status := m.RemoteStatus(); !status.Success || status.Partial {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestUpdateRemoteAwsIdentity(t *testing.T) {
+
+	f := &fakeAws{
+		tags:         Tags{"env": "prod", "aws:region": "eu-west-1"},
+		metadataTags: true,
+	}
+
+	m, logs := newAwsTestManager(t, f)
+	m.AwsIdentity = true
+
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, m.RemoteTags(), Tags{
+		"env":                    "prod",
+		"aws:region":             "eu-west-1",
+		"aws:availability-zone":  "us-east-1a",
+		"aws:instance-type":      "t3.micro",
+		"aws:ami-id":             "ami-0123456789abcdef0",
+		"aws:account-id":         "123456789012",
+		"aws:instance-lifecycle": "on-demand",
+	})
+
+	if !strings.Contains(logs.String(), "identity key collides with tag: aws:region") {
+		t.Errorf("collision wasn't logged:\n%s", logs)
+	}
+}
+
+func TestUpdateRemoteAwsDescribeTags(t *testing.T) {
+
+	f := &fakeAws{
+		tags:     Tags{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"},
+		pageSize: 2,
+	}
+
+	m, _ := newAwsTestManager(t, f)
+
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, m.RemoteTags(), f.tags)
+
+	if n := f.count("instance-id"); n != 1 {
+		t.Errorf("instance-id was requested %d times, want 1", n)
+	}
+
+	// Every page of tags is fetched
+	if n := f.count("DescribeTags"); n != 3 {
+		t.Errorf("DescribeTags was called %d times, want 3", n)
+	}
+}
+
+func TestUpdateRemoteAwsThrottled(t *testing.T) {
+
+	f := &fakeAws{
+		tags:      Tags{"env": "prod"},
+		throttles: 1,
+	}
+
+	m, logs := newAwsTestManager(t, f)
+
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, m.RemoteTags(), f.tags)
+
+	// Throttling is retried once by nextAwsTagsPage
+	// rather than by the SDK underneath it as well
+	if n := f.count("DescribeTags"); n != 2 {
+		t.Errorf("DescribeTags was called %d times, want 2", n)
+	}
+
+	if !strings.Contains(logs.String(), "describe tags throttled") {
+		t.Errorf("throttling wasn't retried by nextAwsTagsPage:\n%s", logs)
+	}
+}
+
+func TestUpdateRemoteAwsRetryConfig(t *testing.T) {
+
+	f := &fakeAws{
+		tags:     Tags{"env": "prod"},
+		failures: 1,
+	}
+
+	m, _ := newAwsTestManager(t, f)
+
+	// Other errors are still retried by the SDK
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := f.count("DescribeTags"); n != 2 {
+		t.Errorf("DescribeTags was called %d times, want 2", n)
+	}
+
+	f.mu.Lock()
+	f.failures = 1
+	f.requests = nil
+	f.mu.Unlock()
+
+	// Using the configured number of attempts
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err == nil {
+		t.Fatal("failed request was retried despite AWS_MAX_ATTEMPTS")
+	}
+
+	if n := f.count("DescribeTags"); n != 1 {
+		t.Errorf("DescribeTags was called %d times, want 1", n)
+	}
+}
+
+func TestUpdateRemoteAwsRequiredKeys(t *testing.T) {
+
+	f := &fakeAws{
+		tags:         Tags{"env": "prod"},
+		late:         Tags{"role": "web"},
+		metadataTags: true,
+	}
+
+	m, _ := newAwsTestManager(t, f)
+
+	if err := m.UpdateRemote(5*time.Second, 5*time.Second, []string{"role"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, m.RemoteTags(), Tags{"env": "prod", "role": "web"})
+
+	if n := f.count("tags/instance"); n != 2 {
+		t.Errorf("tags were listed %d times, want 2", n)
+	}
+}
+
+func TestUpdateRemoteAwsStrictMissingKeys(t *testing.T) {
+
+	f := &fakeAws{
+		tags:         Tags{"env": "prod"},
+		metadataTags: true,
+	}
+
+	m, _ := newAwsTestManager(t, f)
+	m.StrictRemote = true
+
+	err := m.UpdateRemote(5*time.Second, 0, []string{"env", "role"})
+
+	var missingErr *MissingKeysError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("got error %v, want MissingKeysError", err)
+	}
+
+	if len(missingErr.Keys) != 1 || missingErr.Keys[0] != "role" {
+		t.Errorf("got missing keys %v, want [role]", missingErr.Keys)
+	}
+
+	// The incomplete tags are never applied
+	if len(m.RemoteTags()) != 0 {
+		t.Errorf("remote tags were replaced: %v", m.RemoteTags())
+	}
+
+	if status := m.RemoteStatus(); status.Success {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestUpdateRemoteAwsPartial(t *testing.T) {
+
+	f := &fakeAws{
+		tags:         Tags{"env": "prod", "role": "web"},
+		broken:       map[string]bool{"role": true},
+		metadataTags: true,
+	}
+
+	m, _ := newAwsTestManager(t, f)
+
+	// Incomplete tags are better than none at all
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, m.RemoteTags(), Tags{"env": "prod"})
+
+	if status := m.RemoteStatus(); status.Success || !status.Partial {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	// But they never replace previous tags
+	f.mu.Lock()
+	f.tags["env"] = "dev"
+	f.mu.Unlock()
+
+	err := m.UpdateRemote(5*time.Second, 0, nil)
+
+	var partialErr *PartialTagsError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("got error %v, want PartialTagsError", err)
+	}
+
+	assertTags(t, m.RemoteTags(), Tags{"env": "prod"})
+}
+
+func BenchmarkUpdateRemoteAws(b *testing.B) {
+
+	tags := make(Tags)
+	for i := 0; i < 40; i++ {
+		tags[fmt.Sprintf("key-%02d", i)] = fmt.Sprintf("value-%02d", i)
+	}
+
+	for _, metadataTags := range []bool{true, false} {
+
+		name := "describe-tags"
+		if metadataTags {
+			name = "metadata"
+		}
+
+		b.Run(name, func(b *testing.B) {
+
+			f := &fakeAws{
+				tags:         tags,
+				metadataTags: metadataTags,
+				latency:      2 * time.Millisecond,
+			}
+
+			m, logs := newAwsTestManager(b, f)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+
+				if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+					b.Fatal(err)
+				}
+
+				logs.Reset()
+			}
+			b.StopTimer()
+
+			if len(m.RemoteTags()) != len(tags) {
+				b.Fatalf("got %d tags, want %d", len(m.RemoteTags()), len(tags))
+			}
+		})
+	}
+}
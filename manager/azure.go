@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// AzureMetadataEndpoint is the default base URL of
+// the Azure Instance Metadata Service.
+const AzureMetadataEndpoint = "http://169.254.169.254/metadata"
+
+// The IMDS API version which includes compute.tagsList
+const azureApiVersion = "2021-02-01"
+
+func newAzureClient() *http.Client {
+
+	// The metadata service must never be reached through a proxy
+	return &http.Client{
+		Transport: &http.Transport{Proxy: nil},
+	}
+}
+
+func getAzureMetadata(
+	client *http.Client,
+	ctx context.Context,
+	endpoint string,
+	path string,
+) ([]byte, error) {
+
+	url := fmt.Sprintf(
+		"%s/%s?api-version=%s",
+		strings.TrimRight(endpoint, "/"), path, azureApiVersion,
+	)
+
+	// Set up the metadata request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Required by the metadata service
+	req.Header.Set("Metadata", "true")
+
+	// Attempt to perform the request
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("azure metadata %s: %s", path, res.Status)
+	}
+
+	// Read all the contents from the response
+	return io.ReadAll(res.Body)
+}
+
+func getAzureTags(logger *slog.Logger, ctx context.Context, endpoint string) (Tags, error) {
+
+	logger.Debug("getting azure tags")
+
+	data, err := getAzureMetadata(newAzureClient(), ctx, endpoint, "instance")
+	if err != nil {
+		return nil, err
+	}
+
+	var instance struct {
+		Compute struct {
+			Location string `json:"location"`
+			VmSize   string `json:"vmSize"`
+			TagsList []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"tagsList"`
+		} `json:"compute"`
+	}
+
+	// Try and parse the instance metadata
+	err = json.Unmarshal(data, &instance)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(Tags)
+	// Convert tags list into tags
+	for _, tag := range instance.Compute.TagsList {
+		output[tag.Name] = tag.Value
+	}
+
+	// Namespaced to stay clear of user-defined tags
+	output["azure:location"] = instance.Compute.Location
+	output["azure:vm-size"] = instance.Compute.VmSize
+
+	return output, nil
+}
+
+type azureProvider struct {
+	logger   *slog.Logger
+	endpoint string
+}
+
+// NewAzureProvider creates a Provider which retrieves
+// the tags of the current Azure virtual machine.
+func NewAzureProvider(m *Manager) Provider {
+
+	return &azureProvider{
+		logger:   m.GetLogger(),
+		endpoint: m.AzureEndpoint,
+	}
+}
+
+func (p *azureProvider) Name() string {
+	return "azure"
+}
+
+func (p *azureProvider) detectDmi() bool {
+
+	// Azure sets a well-known chassis asset tag on every VM
+	return readDmi("chassis_asset_tag") == "7783-7084-3265-9085-8269-3286-77"
+}
+
+func (p *azureProvider) Detect(ctx context.Context) bool {
+
+	if p.detectDmi() {
+		return true
+	}
+
+	// Fall back to probing the metadata service
+	_, err := getAzureMetadata(newAzureClient(), ctx, p.endpoint, "instance")
+	return err == nil
+}
+
+func (p *azureProvider) Fetch(ctx context.Context) (Tags, error) {
+	return getAzureTags(p.logger, ctx, p.endpoint)
+}
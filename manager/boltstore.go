@@ -0,0 +1,304 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the bolt database
+var (
+	boltLayers  = []byte("layers")
+	boltHistory = []byte("history")
+)
+
+// boltGeneration is how generations are kept in the database
+type boltGeneration struct {
+	Remote Tags `json:"remote"`
+	System Tags `json:"system"`
+}
+
+type boltStore struct {
+	m *Manager
+}
+
+// NewBoltStore creates a Store which keeps each layer
+// in an embedded bolt database in the SystemDir, where
+// every save is a transaction which also records the
+// previous remote and system tags as a generation.
+func NewBoltStore(m *Manager) Store {
+	return &boltStore{m: m}
+}
+
+// dbFile returns the path of the database
+func (s *boltStore) dbFile() string {
+	return filepath.Join(s.m.SystemDir, "systags.db")
+}
+
+// open opens the database, which is only held for a single
+// transaction since bolt locks it for as long as it's open.
+// A missing database results in nil when opened read-only.
+func (s *boltStore) open(readOnly bool) (*bolt.DB, error) {
+
+	path := s.dbFile()
+
+	_, statErr := os.Stat(path)
+	if readOnly && errors.Is(statErr, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	s.m.GetLogger().Debug("opening database: " + path)
+
+	db, err := bolt.Open(path, s.m.FileMode, &bolt.Options{
+		Timeout:  s.m.LockTimeout,
+		ReadOnly: readOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave the attributes of existing files alone
+	if errors.Is(statErr, os.ErrNotExist) {
+
+		err = applyAttrs(path, s.m.fileAttrs())
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// getTags parses the tags kept under key in the bucket
+func getTags(bucket *bolt.Bucket, key string) (Tags, error) {
+
+	tags := make(Tags)
+	if bucket == nil {
+		return tags, nil
+	}
+
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return tags, nil
+	}
+
+	err := json.Unmarshal(data, &tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (s *boltStore) Load(layer string) (Tags, error) {
+
+	db, err := s.open(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if db == nil {
+		return make(Tags), nil
+	}
+
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	var tags Tags
+	err = db.View(func(tx *bolt.Tx) error {
+		tags, err = getTags(tx.Bucket(boltLayers), layer)
+		return err
+	})
+
+	return tags, err
+}
+
+func (s *boltStore) Save(layers map[string]Tags) error {
+
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	return db.Update(func(tx *bolt.Tx) error {
+
+		bucket, err := tx.CreateBucketIfNotExists(boltLayers)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for layer, tags := range layers {
+
+			current, err := getTags(bucket, layer)
+			if err != nil || !sameTags(current, tags) {
+				changed = true
+			}
+		}
+
+		// Nothing to do when none of the layers would change
+		if !changed {
+			s.m.GetLogger().Debug("layers are unchanged")
+			return nil
+		}
+
+		// Nothing to keep before the first save
+		if key, _ := bucket.Cursor().First(); key != nil && s.m.HistoryLimit > 0 {
+
+			err = s.saveGeneration(tx, bucket)
+			if err != nil {
+				return err
+			}
+		}
+
+		for layer, tags := range layers {
+
+			// Attempt to convert the layer to JSON
+			data, err := json.Marshal(tags)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(layer), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// saveGeneration snapshots the current remote and system
+// tags into a new generation as part of the transaction,
+// then prunes the oldest ones beyond the HistoryLimit
+func (s *boltStore) saveGeneration(tx *bolt.Tx, layers *bolt.Bucket) error {
+
+	var gen boltGeneration
+	var err error
+
+	// Corrupt layers are kept as empty tags
+	if gen.Remote, err = getTags(layers, "remote"); err != nil {
+		gen.Remote = make(Tags)
+	}
+
+	if gen.System, err = getTags(layers, "system"); err != nil {
+		gen.System = make(Tags)
+	}
+
+	data, err := json.Marshal(gen)
+	if err != nil {
+		return err
+	}
+
+	history, err := tx.CreateBucketIfNotExists(boltHistory)
+	if err != nil {
+		return err
+	}
+
+	id := time.Now().UTC().Format(generationLayout)
+
+	s.m.GetLogger().Debug("writing generation: " + id)
+
+	err = history.Put([]byte(id), data)
+	if err != nil {
+		return err
+	}
+
+	cursor := history.Cursor()
+
+	count := 0
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		count++
+	}
+
+	// Keys are sorted, so the oldest generations come first
+	for ; count > s.m.HistoryLimit; count-- {
+
+		key, _ := cursor.First()
+		if key == nil {
+			break
+		}
+
+		s.m.GetLogger().Debug("removing generation: " + string(key))
+
+		err := cursor.Delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// History returns the generations kept in the database.
+func (s *boltStore) History() ([]Generation, error) {
+
+	db, err := s.open(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if db == nil {
+		return nil, nil
+	}
+
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	var result []Generation
+
+	err = db.View(func(tx *bolt.Tx) error {
+
+		history := tx.Bucket(boltHistory)
+		if history == nil {
+			return nil
+		}
+
+		cursor := history.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+
+			t, err := time.Parse(generationLayout, string(key))
+			if err != nil {
+				continue
+			}
+
+			var gen boltGeneration
+			err = json.Unmarshal(value, &gen)
+			if err != nil {
+				return err
+			}
+
+			result = append(result, Generation{
+				ID:     string(key),
+				Time:   t,
+				Remote: copyTags(gen.Remote),
+				System: copyTags(gen.System),
+			})
+		}
+
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Conflict is a key which more than one file or
+// environment variable of the same layer defines.
+type Conflict struct {
+	Key   string `json:"key"`
+	Layer string `json:"layer"`
+
+	// Every value of the key, in merge order,
+	// so the last one is the one that's used
+	Values []TagValue `json:"values"`
+}
+
+// ConflictError is returned by LoadFiles when the
+// StrictConfig option is set and keys conflict.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+
+	var keys []string
+	for _, conflict := range e.Conflicts {
+		keys = append(keys, conflict.Layer+"/"+conflict.Key)
+	}
+
+	return "keys defined by multiple files: " + strings.Join(keys, ", ")
+}
+
+// Conflicts returns the keys which are defined by more
+// than one file of the same layer, such as two files
+// in the ConfigDir, sorted by layer priority and key.
+func (m *Manager) Conflicts() []Conflict {
+
+	var result []Conflict
+
+	for _, layer := range m.layers {
+
+		values := make(map[string][]TagValue)
+
+		for _, frag := range m.fragments[layer.Name] {
+			for key, value := range frag.tags {
+
+				values[key] = append(values[key], TagValue{
+					Value:  value,
+					Layer:  layer.Name,
+					Source: frag.source,
+				})
+			}
+		}
+
+		var keys []string
+		for key, list := range values {
+			if len(list) > 1 {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			result = append(result, Conflict{
+				Key:    key,
+				Layer:  layer.Name,
+				Values: values[key],
+			})
+		}
+	}
+
+	return result
+}
+
+// checkConflicts warns about every conflicting key, or
+// fails in strict mode
+func (m *Manager) checkConflicts() error {
+
+	conflicts := m.Conflicts()
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	if m.StrictConfig {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	for _, conflict := range conflicts {
+
+		var sources []string
+		for _, value := range conflict.Values {
+			sources = append(sources, value.Source)
+		}
+
+		m.GetLogger().Warn(fmt.Sprintf("%s is defined by multiple %s files, %s wins: %s",
+			conflict.Key, conflict.Layer, sources[len(sources)-1], strings.Join(sources, ", ")))
+	}
+
+	return nil
+}
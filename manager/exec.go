@@ -0,0 +1,200 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecProviderDir is the default directory containing
+// executables which print remote tags as a JSON object.
+const ExecProviderDir = "/etc/systags.d/providers"
+
+// How long to wait for the output of a killed script to
+// close, as children it started may still be holding it
+const execWaitDelay = 100 * time.Millisecond
+
+// listExecutables returns the sorted paths of all the
+// regular files in dir which have an executable bit set
+func listExecutables(dir string) ([]string, error) {
+
+	// Entries are already sorted by filename
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, entry := range entries {
+
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if info.Mode().Perm()&0111 != 0 {
+			result = append(result, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return result, nil
+}
+
+func runExecProvider(ctx context.Context, path string) (Tags, error) {
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.WaitDelay = execWaitDelay
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+
+		// Include whatever the script complained about
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return nil, err
+	}
+
+	var object map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	decoder.UseNumber()
+
+	// Try and parse the output as a JSON object
+	err = decoder.Decode(&object)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(Tags)
+	// Only scalar values can be used as tags
+	for key, value := range object {
+
+		switch v := value.(type) {
+		case string:
+			output[key] = v
+
+		case json.Number, bool:
+			output[key] = fmt.Sprint(v)
+
+		default:
+			return nil, fmt.Errorf("unsupported value for key: %s", key)
+		}
+	}
+
+	return output, nil
+}
+
+func getExecTags(
+	logger *slog.Logger,
+	ctx context.Context,
+	dir string,
+	timeout time.Duration,
+) (Tags, error) {
+
+	logger.Debug("running provider scripts: " + dir)
+
+	paths, err := listExecutables(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Tags, len(paths))
+	errs := make([]error, len(paths))
+
+	var wg sync.WaitGroup
+
+	for i, path := range paths {
+
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+
+			scriptCtx := ctx
+
+			// Every script gets the whole timeout, even when
+			// providers before it used up the shared deadline
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				scriptCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), timeout)
+				defer cancel()
+			}
+
+			results[i], errs[i] = runExecProvider(scriptCtx, path)
+		}(i, path)
+	}
+
+	wg.Wait()
+
+	output := make(Tags)
+	failed := 0
+
+	// Merge in filename order so later scripts win
+	for i, path := range paths {
+
+		if errs[i] != nil {
+			// Report without discarding the other results
+			logger.Warn(fmt.Sprintf("provider script %s: %v", path, errs[i]))
+			failed++
+			continue
+		}
+
+		for key, value := range results[i] {
+			output[key] = value
+		}
+	}
+
+	if failed > 0 && failed == len(paths) {
+		return nil, errors.New("all provider scripts failed")
+	}
+
+	return output, nil
+}
+
+type execProvider struct {
+	logger  *slog.Logger
+	dir     string
+	timeout time.Duration
+}
+
+// NewExecProvider creates a Provider which merges the
+// tags printed by every executable in the ExecDir.
+func NewExecProvider(m *Manager) Provider {
+
+	return &execProvider{
+		logger:  m.GetLogger(),
+		dir:     m.ExecDir,
+		timeout: m.fetchTimeout,
+	}
+}
+
+func (p *execProvider) Name() string {
+	return "exec"
+}
+
+func (p *execProvider) Detect(_ context.Context) bool {
+
+	paths, err := listExecutables(p.dir)
+	return err == nil && len(paths) > 0
+}
+
+func (p *execProvider) Fetch(ctx context.Context) (Tags, error) {
+	return getExecTags(p.logger, ctx, p.dir, p.timeout)
+}
@@ -0,0 +1,143 @@
+package manager
+
+import (
+	"sort"
+)
+
+// TagValue is a value of a tag along with where it
+// came from.
+type TagValue struct {
+	Value string `json:"value"`
+	Layer string `json:"layer"`
+
+	// The file or environment variable of the value,
+	// empty for layers kept in the Store
+	Source string `json:"source,omitempty"`
+
+	// Whether the value is ignored because the key
+	// is locked to another layer by the policy
+	Ignored bool `json:"ignored,omitempty"`
+}
+
+// Provenance explains the effective value of a tag.
+type Provenance struct {
+	Key string `json:"key"`
+
+	// The value which GetTag returns
+	TagValue
+
+	// The value as written, when it's a template
+	// which Value is the expansion of
+	Template string `json:"template,omitempty"`
+
+	// Every value taking lower precedence or
+	// ignored, ordered from the highest precedence
+	Shadowed []TagValue `json:"shadowed"`
+}
+
+// explainKey collects every value of the key, ordered
+// from the highest precedence
+func (m *Manager) explainKey(key string) []TagValue {
+
+	var values []TagValue
+
+	for i := len(m.layers) - 1; i >= 0; i-- {
+
+		layer := m.layers[i]
+
+		fragments, found := m.fragments[layer.Name]
+		if !found {
+			fragments = []fragment{{tags: m.tags[layer.Name]}}
+		}
+
+		// Later fragments of a layer take precedence
+		for j := len(fragments) - 1; j >= 0; j-- {
+
+			value, found := fragments[j].tags[key]
+			if !found {
+				continue
+			}
+
+			values = append(values, TagValue{
+				Value:   value,
+				Layer:   layer.Name,
+				Source:  fragments[j].source,
+				Ignored: !m.policy.allows(key, layer.Name),
+			})
+		}
+	}
+
+	return values
+}
+
+// ExplainTag returns the provenance of a tag by its key,
+// which shows the layer and file of the value GetTag
+// returns along with the values it shadows. It returns
+// false if the key doesn't exist in any of the layers.
+func (m *Manager) ExplainTag(key string) (Provenance, bool) {
+
+	return m.explainTag(m.newResolver(), key)
+}
+
+// explainTag is ExplainTag using r to expand templates
+func (m *Manager) explainTag(r *resolver, key string) (Provenance, bool) {
+
+	values := m.explainKey(key)
+
+	for i, value := range values {
+
+		if value.Ignored {
+			continue
+		}
+
+		// Ignored values are shadowed regardless
+		shadowed := append([]TagValue{}, values[:i]...)
+		shadowed = append(shadowed, values[i+1:]...)
+
+		prov := Provenance{
+			Key:      key,
+			TagValue: value,
+			Shadowed: shadowed,
+		}
+
+		// Show what GetTag returns, falling back like it does
+		if resolved, err := r.resolve(key); err == nil && resolved != value.Value {
+			prov.Template = value.Value
+			prov.Value = resolved
+		}
+
+		return prov, true
+	}
+
+	return Provenance{}, false
+}
+
+// ExplainTags is like GetTags, but returns the
+// provenance of each of the tags instead of only
+// their values.
+func (m *Manager) ExplainTags(
+	regex bool,
+	pick string,
+	omit string,
+) []Provenance {
+
+	tags := m.GetTags(regex, pick, omit)
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	r := m.newResolver()
+
+	result := make([]Provenance, 0, len(keys))
+	for _, key := range keys {
+
+		prov, _ := m.explainTag(r, key)
+		result = append(result, prov)
+	}
+
+	return result
+}
@@ -0,0 +1,412 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// stageFile writes data to a temporary file next to path
+// and flushes it to disk, returning the temporary path.
+// The file only replaces path once renamed over it.
+func stageFile(path string, data []byte, attrs fileAttrs) (string, error) {
+
+	dir, base := filepath.Split(path)
+
+	var temp *os.File
+	var err error
+
+	// Must be on the same file system to rename atomically
+	for {
+		name := filepath.Join(dir, fmt.Sprintf(".%s.tmp-%d", base, rand.Uint32()))
+
+		temp, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, attrs.perm)
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	cleanup := func(err error) (string, error) {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return "", err
+	}
+
+	// Set before renaming so the file is never exposed
+	if err := applyAttrs(temp.Name(), attrs); err != nil {
+		return cleanup(err)
+	}
+
+	if _, err := temp.Write(data); err != nil {
+		return cleanup(err)
+	}
+
+	// Make sure the contents hit the disk before renaming
+	if err := temp.Sync(); err != nil {
+		return cleanup(err)
+	}
+
+	if err := temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return "", err
+	}
+
+	return temp.Name(), nil
+}
+
+// syncDir flushes the directory entries of dir to disk,
+// which is needed for renames to survive a power loss
+func syncDir(dir string) error {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := d.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	return d.Sync()
+}
+
+// renameFile renames a staged file over its target,
+// which tests replace to simulate failing renames
+var renameFile = os.Rename
+
+// writeFiles atomically replaces each of the files with
+// its data. All the files are staged and flushed first,
+// so that a failure or crash before the renames leaves
+// every file untouched, and the renames are then done
+// back to back followed by a sync of their directories.
+func writeFiles(paths []string, data [][]byte, attrs fileAttrs) error {
+
+	temps := make([]string, 0, len(paths))
+
+	for i, path := range paths {
+
+		temp, err := stageFile(path, data[i], attrs)
+		if err != nil {
+
+			// Don't leave the other staged files behind
+			for _, temp := range temps {
+				_ = os.Remove(temp)
+			}
+
+			return err
+		}
+
+		temps = append(temps, temp)
+	}
+
+	dirs := make(map[string]bool)
+
+	for i, path := range paths {
+
+		err := renameFile(temps[i], path)
+		if err != nil {
+
+			for _, temp := range temps[i:] {
+				_ = os.Remove(temp)
+			}
+
+			return err
+		}
+
+		dirs[filepath.Dir(path)] = true
+	}
+
+	for dir := range dirs {
+
+		err := syncDir(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFile atomically replaces the file with data
+func writeFile(path string, data []byte, attrs fileAttrs) error {
+
+	return writeFiles([]string{path}, [][]byte{data}, attrs)
+}
+
+// readTagsFile reads a Tag JSON file, falling back to its
+// backup when the file is corrupt. Files that don't exist
+// result in empty tags.
+func readTagsFile(logger *slog.Logger, path string) (Tags, error) {
+
+	tags := make(Tags)
+
+	// Attempt to read the contents of the file
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tags, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Try and parse the file as a Tag JSON object
+	err = json.Unmarshal(data, &tags)
+	if err == nil {
+		return tags, nil
+	}
+
+	backup := path + ".bak"
+
+	backupData, backupErr := os.ReadFile(backup)
+	if backupErr != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	backupTags := make(Tags)
+	// Try and parse the backup instead
+	backupErr = json.Unmarshal(backupData, &backupTags)
+	if backupErr != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	logger.Warn(fmt.Sprintf("%s is corrupt, using backup: %v", path, err))
+
+	return backupTags, nil
+}
+
+// numericPrefix returns the number at the start of name
+func numericPrefix(name string) (int, bool) {
+
+	end := 0
+	for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+		end++
+	}
+
+	n, err := strconv.Atoi(name[:end])
+	return n, err == nil
+}
+
+// lessConfigName orders files like systemd drop-ins, but
+// compares numeric prefixes by value so that 9-a.json
+// comes before 10-b.json. Files with a numeric prefix
+// come first, and names which compare equal otherwise
+// are ordered lexically.
+func lessConfigName(a string, b string) bool {
+
+	na, aok := numericPrefix(a)
+	nb, bok := numericPrefix(b)
+
+	if aok != bok {
+		return aok
+	}
+
+	if aok && na != nb {
+		return na < nb
+	}
+
+	return a < b
+}
+
+// reservedFiles are files at the top of the ConfigDir
+// which configure systags rather than containing tags
+var reservedFiles = map[string]bool{
+	LayersFile: true,
+	SchemaFile: true,
+	PolicyFile: true,
+}
+
+// isMasked reports whether the file is a device such as
+// /dev/null, which is used to mask a file of the same
+// name in an earlier directory like systemd does
+func isMasked(path string) bool {
+
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeDevice != 0
+}
+
+// listConfigFiles returns the config files in dir keyed
+// by their slash-separated path relative to dir. When
+// recursive, subdirectories other than the skipped ones
+// are included as well. A missing dir has no files.
+func listConfigFiles(dir string, recursive bool, skip map[string]bool) (map[string]string, error) {
+
+	files := make(map[string]string)
+
+	// WalkDir doesn't follow a symlink as its root
+	root := dir
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		root = resolved
+	}
+
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+
+		if err != nil {
+			if path == root && errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		// Report paths under dir rather than where it resolves
+		path = filepath.Join(dir, rel)
+		rel = filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if rel != "." && (!recursive || skip[path]) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// Ignore files which aren't tags
+		if reservedFiles[rel] {
+			return nil
+		}
+
+		// Ignore files which can't be parsed
+		if _, found := Parsers[filepath.Ext(rel)]; !found {
+			return nil
+		}
+
+		files[rel] = path
+		return nil
+	})
+
+	return files, err
+}
+
+// readTagsDir reads every config file in the search path
+// of dirs. A file in a later dir masks the file with the
+// same relative path in earlier ones, and the files are
+// ordered by lessConfigName of their relative paths so
+// later files take precedence when merged. Missing dirs
+// are ignored.
+func readTagsDir(logger *slog.Logger, dirs []string, recursive bool, skip map[string]bool) ([]fragment, error) {
+
+	files := make(map[string]string)
+
+	for _, dir := range dirs {
+
+		logger.Debug("reading directory: " + dir)
+
+		found, err := listConfigFiles(dir, recursive, skip)
+		if err != nil {
+			return nil, err
+		}
+
+		for rel, path := range found {
+
+			if masked, ok := files[rel]; ok {
+				logger.Debug(fmt.Sprintf("%s masks %s", path, masked))
+			}
+
+			files[rel] = path
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for rel := range files {
+		names = append(names, rel)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return lessConfigName(names[i], names[j])
+	})
+
+	var output []fragment
+
+	// Iterate through all files
+	for _, rel := range names {
+
+		path := files[rel]
+
+		if isMasked(path) {
+			logger.Debug("masked: " + path)
+			continue
+		}
+
+		logger.Debug(path)
+
+		// Attempt to read the contents of the file
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// Try and parse the file by its extension
+		tags, err := parseFile(path, data, Parsers[filepath.Ext(rel)])
+		if err != nil {
+			return nil, err
+		}
+
+		output = append(output, fragment{source: path, tags: tags})
+	}
+
+	return output, nil
+}
+
+// findConfigFile returns the path of the file with the
+// name in the last of dirs which has it, or an empty
+// string if none do or the file is masked
+func findConfigFile(dirs []string, name string) string {
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+
+		path := filepath.Join(dirs[i], name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		if isMasked(path) {
+			return ""
+		}
+
+		return path
+	}
+
+	return ""
+}
+
+// readConfigFile parses the config file by its extension,
+// falling back to JSON. Files that don't exist result in
+// empty tags.
+func readConfigFile(path string) (Tags, error) {
+
+	// Attempt to read the contents of the file
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(Tags), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	parse, found := Parsers[filepath.Ext(path)]
+	if !found {
+		parse = ParseJson
+	}
+
+	return parseFile(path, data, parse)
+}
@@ -0,0 +1,186 @@
+package manager
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestFile writes data to path or fails the test
+func writeTestFile(t *testing.T, path string, data string) {
+
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertFile fails the test unless path contains data
+func assertFile(t *testing.T, path string, data string) {
+
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != data {
+		t.Errorf("%s contains %q, want %q", path, got, data)
+	}
+}
+
+// assertNoTempFiles fails the test if any staged file
+// was left behind in dir
+func assertNoTempFiles(t *testing.T, dir string) {
+
+	t.Helper()
+
+	temps, err := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
+
+func testFileAttrs() fileAttrs {
+	return fileAttrs{perm: 0644, uid: -1, gid: -1}
+}
+
+func TestReadTagsFileTruncated(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "remote.json")
+
+	// A crash while writing without renaming
+	writeTestFile(t, path, `{"env": "pr`)
+	writeTestFile(t, path+".bak", `{"env": "prod"}`)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tags, err := readTagsFile(logger, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, tags, Tags{"env": "prod"})
+
+	// Without a good backup the corruption is reported
+	writeTestFile(t, path+".bak", `{"env": `)
+
+	if _, err := readTagsFile(logger, path); err == nil {
+		t.Error("corrupt file without a good backup was read")
+	}
+}
+
+func TestWriteFilesStageFailure(t *testing.T) {
+
+	dir := t.TempDir()
+
+	first := filepath.Join(dir, "remote.json")
+	writeTestFile(t, first, `{"env": "prod"}`)
+
+	// The directory is missing, so staging fails
+	second := filepath.Join(dir, "missing", "system.json")
+
+	err := writeFiles(
+		[]string{first, second},
+		[][]byte{[]byte(`{"env": "dev"}`), []byte(`{}`)},
+		testFileAttrs(),
+	)
+	if err == nil {
+		t.Fatal("writing into a missing directory succeeded")
+	}
+
+	assertFile(t, first, `{"env": "prod"}`)
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFilesRenameFailure(t *testing.T) {
+
+	dir := t.TempDir()
+
+	first := filepath.Join(dir, "remote.json")
+	second := filepath.Join(dir, "system.json")
+
+	writeTestFile(t, first, `{"env": "prod"}`)
+	writeTestFile(t, second, `{"role": "web"}`)
+
+	errCrash := errors.New("simulated crash")
+
+	renames := 0
+	renameFile = func(oldPath, newPath string) error {
+		renames++
+		return errCrash
+	}
+
+	t.Cleanup(func() {
+		renameFile = os.Rename
+	})
+
+	// Both files are staged before anything is renamed
+	err := writeFiles(
+		[]string{first, second},
+		[][]byte{[]byte(`{"env": "dev"}`), []byte(`{"role": "db"}`)},
+		testFileAttrs(),
+	)
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("got error %v, want %v", err, errCrash)
+	}
+
+	if renames != 1 {
+		t.Errorf("got %d renames, want 1", renames)
+	}
+
+	assertFile(t, first, `{"env": "prod"}`)
+	assertFile(t, second, `{"role": "web"}`)
+	assertNoTempFiles(t, dir)
+}
+
+func TestFileStoreKeepsGoodBackup(t *testing.T) {
+
+	m := NewManager()
+	m.SystemDir = t.TempDir()
+	m.HistoryLimit = 0
+	m.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	path := filepath.Join(m.SystemDir, "remote.json")
+
+	writeTestFile(t, path, `{"env": "pr`)
+	writeTestFile(t, path+".bak", `{"env": "prod"}`)
+
+	store := NewFileStore(m)
+
+	tags, err := store.Load("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, tags, Tags{"env": "prod"})
+
+	err = store.Save(map[string]Tags{
+		"remote": {"env": "dev"},
+		"system": {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The corrupt file was replaced but not backed up
+	assertFile(t, path+".bak", `{"env": "prod"}`)
+
+	tags, err = store.Load("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, tags, Tags{"env": "dev"})
+	assertNoTempFiles(t, m.SystemDir)
+}
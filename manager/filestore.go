@@ -0,0 +1,269 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type fileStore struct {
+	m *Manager
+}
+
+// NewFileStore creates a Store which keeps each layer
+// as a JSON file in the SystemDir, such as remote.json,
+// along with a backup and generations of the files.
+func NewFileStore(m *Manager) Store {
+	return &fileStore{m: m}
+}
+
+// layerFile returns the path of the file for the layer
+func (s *fileStore) layerFile(layer string) string {
+	return filepath.Join(s.m.SystemDir, layer+".json")
+}
+
+// historyDir returns the directory holding the generations
+func (s *fileStore) historyDir() string {
+	return filepath.Join(s.m.SystemDir, "history")
+}
+
+// Load reads the file of the layer, which is recovered
+// from its backup when it's corrupt.
+func (s *fileStore) Load(layer string) (Tags, error) {
+
+	path := s.layerFile(layer)
+
+	s.m.GetLogger().Debug(fmt.Sprintf("reading %s file: %s", layer, path))
+
+	return readTagsFile(s.m.GetLogger(), path)
+}
+
+// Save replaces the files of the layers atomically.
+// Before writing new data, it attempts to create a
+// backup of the existing files, and keeps up to the
+// HistoryLimit generations of the remote and system
+// files. Nothing is written when no file would change.
+func (s *fileStore) Save(layers map[string]Tags) error {
+
+	logger := s.m.GetLogger()
+
+	names := make([]string, 0, len(layers))
+	for layer := range layers {
+		names = append(names, layer)
+	}
+
+	// Write the files in a predictable order
+	sort.Strings(names)
+
+	var paths []string
+	var data [][]byte
+
+	var backupFiles []string
+	var backupData [][]byte
+
+	changed := false
+	for _, layer := range names {
+
+		path := s.layerFile(layer)
+
+		// Attempt to convert the layer to JSON
+		layerJson, err := marshalTags(layers[layer])
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, path)
+		data = append(data, layerJson)
+
+		// Read whatever is currently in the file
+		current, err := os.ReadFile(path)
+		if err != nil || !sameJson(current, layerJson) {
+			changed = true
+		}
+
+		// Only back up files which are still valid so that
+		// a corrupt file never overwrites a good backup
+		if err == nil && json.Valid(current) {
+			backupFiles = append(backupFiles, path+".bak")
+			backupData = append(backupData, current)
+		}
+	}
+
+	// Nothing to do when none of the files would change
+	if !changed {
+		logger.Debug("files are unchanged")
+		return nil
+	}
+
+	logger.Debug("writing backups")
+
+	// Try and backup the contents of the files
+	err := writeFiles(backupFiles, backupData, s.m.fileAttrs())
+	if err != nil {
+		return err
+	}
+
+	// Keep the current state around for rollbacks
+	if len(backupFiles) > 0 {
+		err = s.saveGeneration()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		logger.Debug("writing file: " + path)
+	}
+
+	// Attempt to write all files as consistently as possible
+	return writeFiles(paths, data, s.m.fileAttrs())
+}
+
+// saveGeneration snapshots the current contents of the
+// remote and system files into a new generation, then
+// prunes the oldest ones beyond the HistoryLimit
+func (s *fileStore) saveGeneration() error {
+
+	if s.m.HistoryLimit <= 0 {
+		return nil
+	}
+
+	var data [][]byte
+
+	for _, layer := range []string{"remote", "system"} {
+
+		// Corrupt files are kept as empty tags
+		current, err := os.ReadFile(s.layerFile(layer))
+		if err != nil || !json.Valid(current) {
+			current = []byte("{}")
+		}
+
+		data = append(data, current)
+	}
+
+	now := time.Now().UTC()
+	dir := filepath.Join(s.historyDir(), now.Format(generationLayout))
+
+	s.m.GetLogger().Debug("writing generation: " + dir)
+
+	err := s.m.makeDir(s.historyDir())
+	if err != nil {
+		return err
+	}
+
+	err = s.m.makeDir(dir)
+	if err != nil {
+		return err
+	}
+
+	err = writeFiles(
+		[]string{
+			filepath.Join(dir, "remote.json"),
+			filepath.Join(dir, "system.json"),
+		},
+		data,
+		s.m.fileAttrs(),
+	)
+	if err != nil {
+		return err
+	}
+
+	ids, err := s.generationIDs()
+	if err != nil {
+		return err
+	}
+
+	// Remove the oldest generations
+	for len(ids) > s.m.HistoryLimit {
+
+		s.m.GetLogger().Debug("removing generation: " + ids[0])
+
+		err := os.RemoveAll(filepath.Join(s.historyDir(), ids[0]))
+		if err != nil {
+			return err
+		}
+
+		ids = ids[1:]
+	}
+
+	return nil
+}
+
+// generationIDs returns the IDs of all the generations
+// sorted from oldest to newest
+func (s *fileStore) generationIDs() ([]string, error) {
+
+	entries, err := os.ReadDir(s.historyDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+
+		// Ignore anything that isn't a generation
+		if _, err := time.Parse(generationLayout, entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+
+		ids = append(ids, entry.Name())
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// loadGeneration reads the generation with the given ID
+func (s *fileStore) loadGeneration(id string) (Generation, error) {
+
+	gen := Generation{ID: id}
+
+	t, err := time.Parse(generationLayout, id)
+	if err != nil {
+		return gen, fmt.Errorf("invalid generation: %s", id)
+	}
+
+	gen.Time = t
+	dir := filepath.Join(s.historyDir(), id)
+
+	gen.Remote, err = readTagsFile(s.m.GetLogger(), filepath.Join(dir, "remote.json"))
+	if err != nil {
+		return gen, err
+	}
+
+	gen.System, err = readTagsFile(s.m.GetLogger(), filepath.Join(dir, "system.json"))
+	if err != nil {
+		return gen, err
+	}
+
+	return gen, nil
+}
+
+// History returns the generations kept in the SystemDir.
+func (s *fileStore) History() ([]Generation, error) {
+
+	ids, err := s.generationIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Generation, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+
+		gen, err := s.loadGeneration(ids[i])
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, gen)
+	}
+
+	return result, nil
+}
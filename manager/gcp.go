@@ -0,0 +1,266 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// GcpMetadataEndpoint is the default base URL of the
+// Compute Engine metadata server.
+const GcpMetadataEndpoint = "http://metadata.google.internal/computeMetadata/v1"
+
+// GcpComputeEndpoint is the base URL of the Compute
+// Engine API used to look up the instance labels.
+const GcpComputeEndpoint = "https://compute.googleapis.com/compute/v1"
+
+func getGcpMetadata(
+	client *http.Client,
+	ctx context.Context,
+	endpoint string,
+	path string,
+) (string, error) {
+
+	url := strings.TrimRight(endpoint, "/") + "/" + path
+
+	// Set up the metadata request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Required by the metadata server
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	// Attempt to perform the request
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gcp metadata %s: %s", path, res.Status)
+	}
+
+	// Make sure we're talking to an actual metadata server
+	if res.Header.Get("Metadata-Flavor") != "Google" {
+		return "", fmt.Errorf("gcp metadata %s: missing flavor header", path)
+	}
+
+	// Read all the contents from the response
+	value, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// newGcpClient creates a client for the metadata server
+func newGcpClient() *http.Client {
+
+	// The metadata server must never be reached through a proxy
+	return &http.Client{
+		Transport: &http.Transport{Proxy: nil},
+	}
+}
+
+func getGcpLabels(
+	client *http.Client,
+	ctx context.Context,
+	endpoint string,
+	computeEndpoint string,
+	project string,
+	zone string,
+	name string,
+) (Tags, error) {
+
+	// Request an access token for the default service account
+	token, err := getGcpMetadata(client, ctx, endpoint, "instance/service-accounts/default/token")
+	if err != nil {
+		return nil, err
+	}
+
+	var auth struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	// Try and parse the token response
+	err = json.Unmarshal([]byte(token), &auth)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf(
+		"%s/projects/%s/zones/%s/instances/%s",
+		computeEndpoint, project, zone, name,
+	)
+
+	// Set up the instances.get request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+auth.AccessToken)
+
+	// Unlike the metadata server, the API may need a proxy
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gcp instances.get: %s", res.Status)
+	}
+
+	var instance struct {
+		Labels Tags `json:"labels"`
+	}
+
+	// Try and parse the instance resource
+	err = json.NewDecoder(res.Body).Decode(&instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return instance.Labels, nil
+}
+
+func getGcpTags(
+	logger *slog.Logger,
+	ctx context.Context,
+	endpoint string,
+	computeEndpoint string,
+	attributes []string,
+) (Tags, error) {
+
+	logger.Debug("getting gcp tags")
+
+	client := newGcpClient()
+
+	// Retrieve the entire instance directory at once
+	data, err := getGcpMetadata(client, ctx, endpoint, "instance/?recursive=true")
+	if err != nil {
+		return nil, err
+	}
+
+	var instance struct {
+		Attributes Tags   `json:"attributes"`
+		Name       string `json:"name"`
+		Zone       string `json:"zone"`
+	}
+
+	// Try and parse the instance directory
+	err = json.Unmarshal([]byte(data), &instance)
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := getGcpMetadata(client, ctx, endpoint, "project/project-id")
+	if err != nil {
+		return nil, err
+	}
+
+	// Zone is returned as projects/<number>/zones/<zone>
+	zone := path.Base(instance.Zone)
+
+	output := make(Tags)
+	// Only the selected attributes are included, as
+	// others like kube-env hold credentials and scripts
+	for _, key := range attributes {
+
+		value, found := instance.Attributes[key]
+		if !found {
+			continue
+		}
+
+		if strings.Contains(value, "\n") {
+			logger.Warn("skipping multi-line gcp attribute: " + key)
+			continue
+		}
+
+		output[key] = value
+	}
+
+	// Labels are not exposed by the metadata server so they
+	// have to be looked up through the Compute Engine API,
+	// which requires the instance to have a service account
+	labels, err := getGcpLabels(client, ctx, endpoint, computeEndpoint, project, zone, instance.Name)
+	if err == nil {
+		for key, value := range labels {
+			output[key] = value
+		}
+	} else {
+		logger.Warn("skipping gcp labels: " + err.Error())
+	}
+
+	// Label keys can't contain colons so these never collide
+	output["gcp:project"] = project
+	output["gcp:zone"] = zone
+
+	return output, nil
+}
+
+type gcpProvider struct {
+	logger          *slog.Logger
+	endpoint        string
+	computeEndpoint string
+	attributes      []string
+}
+
+// NewGcpProvider creates a Provider which retrieves
+// the tags of the current Compute Engine instance.
+func NewGcpProvider(m *Manager) Provider {
+
+	return &gcpProvider{
+		logger:          m.GetLogger(),
+		endpoint:        m.GcpEndpoint,
+		computeEndpoint: m.GcpComputeEndpoint,
+		attributes:      m.GcpAttributes,
+	}
+}
+
+func (p *gcpProvider) Name() string {
+	return "gcp"
+}
+
+func (p *gcpProvider) detectDmi() bool {
+
+	return readDmi("product_name") == "Google Compute Engine" ||
+		readDmi("sys_vendor") == "Google"
+}
+
+func (p *gcpProvider) Detect(ctx context.Context) bool {
+
+	if p.detectDmi() {
+		return true
+	}
+
+	// Fall back to probing the metadata server
+	_, err := getGcpMetadata(newGcpClient(), ctx, p.endpoint, "project/project-id")
+	return err == nil
+}
+
+func (p *gcpProvider) Fetch(ctx context.Context) (Tags, error) {
+	return getGcpTags(p.logger, ctx, p.endpoint, p.computeEndpoint, p.attributes)
+}
@@ -0,0 +1,238 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGcp serves just enough of the metadata server and
+// the Compute Engine API for the gcp provider to fetch
+// the tags of an instance
+type fakeGcp struct {
+	mu sync.Mutex
+
+	// The custom metadata attributes of the instance
+	attributes Tags
+
+	// The labels of the instance
+	labels Tags
+
+	// Whether responses lack the Metadata-Flavor header
+	noFlavor bool
+
+	// Whether instances.get is denied
+	denyLabels bool
+
+	// The paths of the instances.get requests
+	instances []string
+}
+
+const fakeGcpToken = "fake-token"
+
+func (f *fakeGcp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if path, found := strings.CutPrefix(r.URL.Path, "/computeMetadata/v1/"); found {
+
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+			return
+		}
+
+		if !f.noFlavor {
+			w.Header().Set("Metadata-Flavor", "Google")
+		}
+
+		f.serveMetadata(w, r, path)
+		return
+	}
+
+	if path, found := strings.CutPrefix(r.URL.Path, "/compute/v1/"); found {
+
+		f.instances = append(f.instances, path)
+
+		if f.denyLabels || r.Header.Get("Authorization") != "Bearer "+fakeGcpToken {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":   "web-1",
+			"labels": f.labels,
+		})
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func (f *fakeGcp) serveMetadata(w http.ResponseWriter, r *http.Request, path string) {
+
+	switch path {
+	case "instance/":
+		if r.URL.Query().Get("recursive") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"attributes": f.attributes,
+			"name":       "web-1",
+			"zone":       "projects/123456789012/zones/us-central1-a",
+		})
+
+	case "project/project-id":
+		_, _ = io.WriteString(w, "my-project")
+
+	case "instance/service-accounts/default/token":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fakeGcpToken,
+			"expires_in":   3600,
+			"token_type":   "Bearer",
+		})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newGcpTestManager creates a Manager whose gcp provider
+// talks to f, with its log written to the returned buffer
+func newGcpTestManager(t *testing.T, f *fakeGcp) (*Manager, *bytes.Buffer) {
+
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+
+	m := NewManager()
+	m.ConfigDir = filepath.Join(dir, "config.d")
+	m.SystemDir = filepath.Join(dir, "system")
+	m.Provider = "gcp"
+	m.GcpEndpoint = server.URL + "/computeMetadata/v1"
+	m.GcpComputeEndpoint = server.URL + "/compute/v1"
+
+	var logs bytes.Buffer
+	m.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	if err := m.LoadFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	return m, &logs
+}
+
+func TestUpdateRemoteGcp(t *testing.T) {
+
+	f := &fakeGcp{
+		attributes: Tags{
+			"team":         "ads",
+			"kube-env":     "KUBELET_CERT: secret\nKUBELET_KEY: secret",
+			"configure-sh": "#!/bin/bash",
+			"startup-note": "line 1\nline 2",
+		},
+		labels: Tags{"env": "prod"},
+	}
+
+	m, logs := newGcpTestManager(t, f)
+	m.GcpAttributes = []string{"team", "startup-note", "missing"}
+
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the selected single-line attributes are kept
+	assertTags(t, m.RemoteTags(), Tags{
+		"team":        "ads",
+		"env":         "prod",
+		"gcp:project": "my-project",
+		"gcp:zone":    "us-central1-a",
+	})
+
+	if !strings.Contains(logs.String(), "skipping multi-line gcp attribute: startup-note") {
+		t.Errorf("multi-line attribute wasn't logged:\n%s", logs)
+	}
+
+	want := "projects/my-project/zones/us-central1-a/instances/web-1"
+	if len(f.instances) != 1 || f.instances[0] != want {
+		t.Errorf("got instances.get requests %v, want [%s]", f.instances, want)
+	}
+}
+
+func TestUpdateRemoteGcpNoAttributes(t *testing.T) {
+
+	f := &fakeGcp{
+		attributes: Tags{"team": "ads", "kube-env": "secret"},
+	}
+
+	m, _ := newGcpTestManager(t, f)
+
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attributes are opt-in
+	assertTags(t, m.RemoteTags(), Tags{
+		"gcp:project": "my-project",
+		"gcp:zone":    "us-central1-a",
+	})
+}
+
+func TestUpdateRemoteGcpLabelsDenied(t *testing.T) {
+
+	f := &fakeGcp{
+		attributes: Tags{"team": "ads"},
+		labels:     Tags{"env": "prod"},
+		denyLabels: true,
+	}
+
+	m, logs := newGcpTestManager(t, f)
+	m.GcpAttributes = []string{"team"}
+
+	// Labels are optional, so this only warns
+	if err := m.UpdateRemote(5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, m.RemoteTags(), Tags{
+		"team":        "ads",
+		"gcp:project": "my-project",
+		"gcp:zone":    "us-central1-a",
+	})
+
+	if !strings.Contains(logs.String(), "skipping gcp labels") {
+		t.Errorf("label failure wasn't logged:\n%s", logs)
+	}
+
+	if status := m.RemoteStatus(); !status.Success {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestUpdateRemoteGcpMissingFlavor(t *testing.T) {
+
+	f := &fakeGcp{noFlavor: true}
+
+	m, _ := newGcpTestManager(t, f)
+
+	// Not an actual metadata server
+	err := m.UpdateRemote(5*time.Second, 0, nil)
+	if err == nil || !strings.Contains(err.Error(), "missing flavor header") {
+		t.Fatalf("got error %v, want missing flavor header", err)
+	}
+
+	if len(m.RemoteTags()) != 0 {
+		t.Errorf("remote tags were replaced: %v", m.RemoteTags())
+	}
+}
@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Layout of generation IDs, which sort chronologically
+const generationLayout = "20060102T150405.000000000Z"
+
+// Generation is a snapshot of the remote and system tags
+// taken right before they were replaced by SaveFiles.
+type Generation struct {
+	// Identifies the generation, based on Time
+	ID string
+
+	// When the snapshot was taken
+	Time time.Time
+
+	// The remote tags at the time
+	Remote Tags
+
+	// The system tags at the time
+	System Tags
+}
+
+// History returns the generations of the remote and
+// system tags kept by the Store, newest first.
+func (m *Manager) History() ([]Generation, error) {
+
+	store, ok := m.Store.(HistoryStore)
+	if !ok {
+		return nil, ErrNoHistory
+	}
+
+	return store.History()
+}
+
+// Rollback replaces the Manager's remote and system tags
+// with the ones from a generation, identified either by
+// its ID or by its position in History starting from 1.
+// The change only persists once SaveFiles is called,
+// which in turn keeps the current state as a generation.
+func (m *Manager) Rollback(generation string) error {
+
+	history, err := m.History()
+	if err != nil {
+		return err
+	}
+
+	var gen *Generation
+
+	// Positions are easier to type than IDs
+	if n, err := strconv.Atoi(generation); err == nil {
+		if n >= 1 && n <= len(history) {
+			gen = &history[n-1]
+		}
+	} else {
+		for i := range history {
+			if history[i].ID == generation {
+				gen = &history[i]
+			}
+		}
+	}
+
+	if gen == nil {
+		return fmt.Errorf("generation not found: %s", generation)
+	}
+
+	m.GetLogger().Debug("rolling back to generation: " + gen.ID)
+
+	m.recordAuditDiff("rollback", "remote", m.tags["remote"], gen.Remote)
+	m.recordAuditDiff("rollback", "system", m.tags["system"], gen.System)
+
+	m.tags["remote"] = gen.Remote
+	m.tags["system"] = gen.System
+
+	return nil
+}
+
+// TagChanges summarizes the keys which differ between
+// two sets of tags.
+type TagChanges struct {
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+}
+
+// DiffTags returns the keys which were added, removed,
+// or modified when going from the old to the new tags.
+func DiffTags(old Tags, new Tags) TagChanges {
+
+	var changes TagChanges
+
+	for key, value := range new {
+
+		prev, found := old[key]
+		if !found {
+			changes.Added = append(changes.Added, key)
+		} else if prev != value {
+			changes.Modified = append(changes.Modified, key)
+		}
+	}
+
+	for key := range old {
+		if _, found := new[key]; !found {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Modified)
+
+	return changes
+}
+
+// sameJson reports whether two JSON documents are
+// byte for byte identical, ignoring surrounding space
+func sameJson(a []byte, b []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+}
+
+// marshalTags converts tags into the file format
+func marshalTags(tags Tags) ([]byte, error) {
+	return json.MarshalIndent(tags, "", "\t")
+}
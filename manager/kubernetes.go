@@ -0,0 +1,440 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The directory where pods have their service account mounted
+const kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubeletConfigs lists the kubeconfig files which are
+// typically used by the kubelet on worker nodes, and
+// which are tried when no kubeconfig is configured.
+var KubeletConfigs = []string{
+	"/var/lib/kubelet/kubeconfig",
+	"/etc/kubernetes/kubelet.conf",
+}
+
+// kubeClient holds everything needed to talk to the API server
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// kubeConfig is the subset of the kubeconfig file format
+// which is needed to authenticate against the API server
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Exec                  *struct {
+				Command string   `yaml:"command"`
+				Args    []string `yaml:"args"`
+				Env     []struct {
+					Name  string `yaml:"name"`
+					Value string `yaml:"value"`
+				} `yaml:"env"`
+			} `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// readKubeData returns either the decoded inline data or
+// the contents of the file, relative to the kubeconfig
+func readKubeData(dir string, file string, data string) ([]byte, error) {
+
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	if file == "" {
+		return nil, nil
+	}
+
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+
+	return os.ReadFile(file)
+}
+
+// getKubeExecToken runs a client-go credential plugin,
+// such as the one used by EKS, and returns its token
+func getKubeExecToken(ctx context.Context, command string, args []string, env []string) (string, error) {
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), env...)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	var credential struct {
+		Status struct {
+			Token string `json:"token"`
+		} `json:"status"`
+	}
+
+	// Try and parse the ExecCredential object
+	err = json.Unmarshal(out, &credential)
+	if err != nil {
+		return "", err
+	}
+
+	if credential.Status.Token == "" {
+		return "", errors.New("credential plugin returned no token")
+	}
+
+	return credential.Status.Token, nil
+}
+
+func newKubeClientFromConfig(ctx context.Context, path string) (*kubeClient, error) {
+
+	// Attempt to read the contents of the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config kubeConfig
+	// Try and parse the file as a kubeconfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+
+	var clusterName, userName string
+	// Look up the current context
+	for _, c := range config.Contexts {
+		if c.Name == config.CurrentContext {
+			clusterName = c.Context.Cluster
+			userName = c.Context.User
+		}
+	}
+
+	if clusterName == "" {
+		return nil, fmt.Errorf("kubeconfig %s: context not found: %s", path, config.CurrentContext)
+	}
+
+	result := &kubeClient{}
+	tlsConfig := &tls.Config{}
+
+	for _, c := range config.Clusters {
+
+		if c.Name != clusterName {
+			continue
+		}
+
+		result.server = c.Cluster.Server
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+
+		ca, err := readKubeData(dir, c.Cluster.CertificateAuthority, c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, err
+		}
+
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			tlsConfig.RootCAs.AppendCertsFromPEM(ca)
+		}
+	}
+
+	if result.server == "" {
+		return nil, fmt.Errorf("kubeconfig %s: cluster not found: %s", path, clusterName)
+	}
+
+	for _, u := range config.Users {
+
+		if u.Name != userName {
+			continue
+		}
+
+		user := u.User
+
+		cert, err := readKubeData(dir, user.ClientCertificate, user.ClientCertificateData)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := readKubeData(dir, user.ClientKey, user.ClientKeyData)
+		if err != nil {
+			return nil, err
+		}
+
+		if cert != nil {
+
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+
+		switch {
+		case user.Token != "":
+			result.token = user.Token
+
+		case user.TokenFile != "":
+			token, err := readKubeData(dir, user.TokenFile, "")
+			if err != nil {
+				return nil, err
+			}
+
+			result.token = strings.TrimSpace(string(token))
+
+		case user.Exec != nil:
+			var env []string
+			for _, e := range user.Exec.Env {
+				env = append(env, e.Name+"="+e.Value)
+			}
+
+			result.token, err = getKubeExecToken(ctx, user.Exec.Command, user.Exec.Args, env)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	return result, nil
+}
+
+func newKubeClientInCluster() (*kubeClient, error) {
+
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside a cluster")
+	}
+
+	token, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs: x509.NewCertPool(),
+	}
+	tlsConfig.RootCAs.AppendCertsFromPEM(ca)
+
+	return &kubeClient{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func getKubeTags(
+	logger *slog.Logger,
+	ctx context.Context,
+	client *kubeClient,
+	node string,
+	prefix string,
+	annotations []string,
+) (Tags, error) {
+
+	// Otherwise every node would be listed instead
+	if node == "" {
+		return nil, errors.New("kubernetes node name is empty")
+	}
+
+	logger.Debug("getting kubernetes tags for node: " + node)
+
+	endpoint := strings.TrimRight(client.server, "/") + "/api/v1/nodes/" + url.PathEscape(node)
+
+	// Set up the node request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	}
+
+	// Attempt to perform the request
+	res, err := client.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			// Ignore
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes node %s: %s", node, res.Status)
+	}
+
+	var object struct {
+		Metadata struct {
+			Labels      Tags `json:"labels"`
+			Annotations Tags `json:"annotations"`
+		} `json:"metadata"`
+	}
+
+	// Try and parse the node object
+	err = json.NewDecoder(res.Body).Decode(&object)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(Tags)
+	// Every label is included
+	for key, value := range object.Metadata.Labels {
+		output[prefix+key] = value
+	}
+
+	// Only the selected annotations are included
+	for _, key := range annotations {
+		if value, found := object.Metadata.Annotations[key]; found {
+			output[prefix+key] = value
+		}
+	}
+
+	return output, nil
+}
+
+type kubeProvider struct {
+	logger      *slog.Logger
+	config      string
+	node        string
+	prefix      string
+	annotations []string
+}
+
+// NewKubeProvider creates a Provider which retrieves the
+// labels and selected annotations of the Kubernetes node.
+func NewKubeProvider(m *Manager) Provider {
+
+	node := m.KubeNode
+	if node == "" {
+		// Nodes are registered by hostname by default
+		node, _ = os.Hostname()
+	}
+
+	return &kubeProvider{
+		logger:      m.GetLogger(),
+		config:      m.KubeConfig,
+		node:        node,
+		prefix:      m.KubePrefix,
+		annotations: m.KubeAnnotations,
+	}
+}
+
+// configPath returns the kubeconfig to use or an empty
+// string if the in-cluster configuration should be used
+func (p *kubeProvider) configPath() string {
+
+	if p.config != "" {
+		return p.config
+	}
+
+	// Pods have their own service account
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return ""
+	}
+
+	for _, path := range KubeletConfigs {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+func (p *kubeProvider) Name() string {
+	return "kubernetes"
+}
+
+func (p *kubeProvider) Detect(_ context.Context) bool {
+
+	if p.configPath() != "" {
+		return true
+	}
+
+	_, err := os.Stat(filepath.Join(kubeServiceAccountDir, "token"))
+	return err == nil && os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+func (p *kubeProvider) Fetch(ctx context.Context) (Tags, error) {
+
+	var client *kubeClient
+	var err error
+
+	if path := p.configPath(); path != "" {
+		client, err = newKubeClientFromConfig(ctx, path)
+	} else {
+		client, err = newKubeClientInCluster()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return getKubeTags(p.logger, ctx, client, p.node, p.prefix, p.annotations)
+}
@@ -0,0 +1,258 @@
+package manager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKube serves just enough of the Kubernetes API
+// for the kubernetes provider to fetch a node
+type fakeKube struct {
+	mu sync.Mutex
+
+	// The name of the only node
+	node string
+
+	labels      Tags
+	annotations Tags
+
+	// The bearer token required, if any
+	token string
+
+	// The paths of the requests
+	requests []string
+}
+
+func (f *fakeKube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.URL.Path)
+
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path != "/api/v1/nodes/"+f.node {
+		http.NotFound(w, r)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind": "Node",
+		"metadata": map[string]interface{}{
+			"name":        f.node,
+			"labels":      f.labels,
+			"annotations": f.annotations,
+		},
+	})
+}
+
+func newKubeTestFake() *fakeKube {
+
+	return &fakeKube{
+		node: "node-1",
+		labels: Tags{
+			"topology.kubernetes.io/zone": "us-east-1a",
+			"node-role":                   "worker",
+		},
+		annotations: Tags{
+			"team":                         "ads",
+			"node.alpha.kubernetes.io/ttl": "0",
+		},
+		token: "secret-token",
+	}
+}
+
+func newKubeTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// writeKubeConfig writes a kubeconfig for server into dir,
+// with the given user fields, and returns its path
+func writeKubeConfig(t *testing.T, dir string, server *httptest.Server, ca bool, user string) string {
+
+	t.Helper()
+
+	cluster := "    server: " + server.URL + "\n"
+
+	if ca {
+		cert := pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: server.Certificate().Raw,
+		})
+
+		cluster += "    certificate-authority-data: " + base64.StdEncoding.EncodeToString(cert) + "\n"
+	}
+
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: default
+clusters:
+- name: cluster
+  cluster:
+%scontexts:
+- name: default
+  context:
+    cluster: cluster
+    user: kubelet
+users:
+- name: kubelet
+  user:
+%s`, cluster, user)
+
+	path := filepath.Join(dir, "kubeconfig")
+	writeTestFile(t, path, config)
+
+	return path
+}
+
+func TestGetKubeTags(t *testing.T) {
+
+	f := newKubeTestFake()
+
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	client := &kubeClient{
+		server: server.URL,
+		token:  f.token,
+		client: server.Client(),
+	}
+
+	tags, err := getKubeTags(
+		newKubeTestLogger(), context.Background(), client,
+		"node-1", "k8s:", []string{"team", "missing"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every label but only the selected annotations
+	assertTags(t, tags, Tags{
+		"k8s:topology.kubernetes.io/zone": "us-east-1a",
+		"k8s:node-role":                   "worker",
+		"k8s:team":                        "ads",
+	})
+}
+
+func TestGetKubeTagsErrors(t *testing.T) {
+
+	f := newKubeTestFake()
+
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	client := &kubeClient{
+		server: server.URL,
+		token:  f.token,
+		client: server.Client(),
+	}
+
+	_, err := getKubeTags(newKubeTestLogger(), context.Background(), client, "node-2", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got error %v for a missing node, want 404", err)
+	}
+
+	client.token = "wrong-token"
+
+	_, err = getKubeTags(newKubeTestLogger(), context.Background(), client, "node-1", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got error %v for a wrong token, want 401", err)
+	}
+
+	requests := len(f.requests)
+
+	// An empty name would list every node instead
+	_, err = getKubeTags(newKubeTestLogger(), context.Background(), client, "", "", nil)
+	if err == nil {
+		t.Error("empty node name was accepted")
+	}
+
+	if len(f.requests) != requests {
+		t.Errorf("empty node name was requested: %v", f.requests[requests:])
+	}
+}
+
+func TestNewKubeClientFromConfig(t *testing.T) {
+
+	f := newKubeTestFake()
+
+	server := httptest.NewUnstartedServer(f)
+	t.Cleanup(server.Close)
+
+	// Rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "token"), f.token+"\n")
+
+	tests := []struct {
+		name string
+		ca   bool
+		user string
+		fail bool
+	}{
+		{name: "token", ca: true, user: "    token: " + f.token + "\n"},
+		{name: "token file", ca: true, user: "    tokenFile: token\n"},
+		{name: "no ca", ca: false, user: "    token: " + f.token + "\n", fail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			path := writeKubeConfig(t, dir, server, test.ca, test.user)
+
+			client, err := newKubeClientFromConfig(context.Background(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if client.token != f.token {
+				t.Errorf("got token %q, want %q", client.token, f.token)
+			}
+
+			tags, err := getKubeTags(newKubeTestLogger(), context.Background(), client, "node-1", "", nil)
+
+			// The server certificate is only trusted through the CA data
+			if test.fail {
+				if err == nil {
+					t.Error("untrusted server certificate was accepted")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertTags(t, tags, f.labels)
+		})
+	}
+}
+
+func TestNewKubeClientFromConfigMissingContext(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	writeTestFile(t, path, "current-context: missing\n")
+
+	_, err := newKubeClientFromConfig(context.Background(), path)
+	if err == nil || !strings.Contains(err.Error(), "context not found") {
+		t.Errorf("got error %v, want context not found", err)
+	}
+}
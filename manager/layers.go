@@ -0,0 +1,280 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LayersFile is the file in the ConfigDir which defines
+// additional layers, it's never read as config tags. Like
+// config files, the one in the last directory is used.
+const LayersFile = "layers.json"
+
+// Sources which layers can read their tags from
+const (
+	// Every JSON file in a directory, merged by name
+	SourceDir = "dir"
+
+	// A single JSON file
+	SourceFile = "file"
+
+	// Environment variables starting with a prefix
+	SourceEnv = "env"
+
+	// The layer of the same name in the Manager's Store,
+	// only used by the built-in remote and system layers
+	SourceStore = "store"
+)
+
+// Layer is a named set of tags read from a source. The
+// tags of layers with a higher priority take precedence
+// over the tags of layers with a lower priority.
+type Layer struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Source   string `json:"source"`
+
+	// The file of file sources, or the colon-separated
+	// search path of directories of dir sources
+	Path string `json:"path,omitempty"`
+
+	// The variable prefix of env sources, which is
+	// stripped and the rest lower cased to get keys
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// DefaultLayers returns the built-in remote, config, and
+// system layers, which are always present.
+func (m *Manager) DefaultLayers() []Layer {
+
+	return []Layer{
+		{Name: "remote", Priority: 10, Source: SourceStore},
+		{Name: "config", Priority: 20, Source: SourceDir, Path: m.ConfigDir},
+		{Name: "system", Priority: 30, Source: SourceStore},
+	}
+}
+
+// layersConfig is the format of the LayersFile
+type layersConfig struct {
+	Layers []Layer `json:"layers"`
+}
+
+// loadLayers reads the LayersFile from the ConfigDir and
+// combines it with the built-in layers. Built-in layers
+// can be listed to change their priority, but not their
+// source. The result is sorted from lowest priority.
+func (m *Manager) loadLayers() ([]Layer, error) {
+
+	layers := m.DefaultLayers()
+	layersFile := findConfigFile(m.ConfigDirs(), LayersFile)
+
+	if layersFile != "" {
+
+		m.GetLogger().Debug("reading layers file: " + layersFile)
+
+		// Attempt to read the contents of the file
+		data, err := os.ReadFile(layersFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config := layersConfig{}
+		// Try and parse the file as a layers JSON object
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", layersFile, err)
+		}
+
+		for _, layer := range config.Layers {
+
+			err := m.addLayer(&layers, layer)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", layersFile, err)
+			}
+		}
+	}
+
+	// Ties would make the merge order ambiguous
+	seen := make(map[int]string)
+	for _, layer := range layers {
+
+		if other, found := seen[layer.Priority]; found {
+			return nil, fmt.Errorf("%s: layers %s and %s have the same priority: %d",
+				layersFile, other, layer.Name, layer.Priority)
+		}
+
+		seen[layer.Priority] = layer.Name
+	}
+
+	sort.Slice(layers, func(i, j int) bool {
+		return layers[i].Priority < layers[j].Priority
+	})
+
+	return layers, nil
+}
+
+// addLayer validates the layer and adds it to layers
+func (m *Manager) addLayer(layers *[]Layer, layer Layer) error {
+
+	if layer.Name == "" {
+		return errors.New("layer is missing a name")
+	}
+
+	for i, existing := range *layers {
+
+		if existing.Name != layer.Name {
+			continue
+		}
+
+		// Only built-in layers can be listed again
+		if i >= len(m.DefaultLayers()) {
+			return fmt.Errorf("duplicate layer: %s", layer.Name)
+		}
+
+		if layer.Source != "" && layer.Source != existing.Source {
+			return fmt.Errorf("source of built-in layer can't be changed: %s", layer.Name)
+		}
+
+		(*layers)[i].Priority = layer.Priority
+		return nil
+	}
+
+	switch layer.Source {
+	case SourceDir, SourceFile:
+		if layer.Path == "" {
+			return fmt.Errorf("layer is missing a path: %s", layer.Name)
+		}
+
+	case SourceEnv:
+		if layer.Prefix == "" {
+			return fmt.Errorf("layer is missing a prefix: %s", layer.Name)
+		}
+
+	case SourceStore:
+		// Only the built-in layers are saved to the Store
+		return fmt.Errorf("only built-in layers can use the store source: %s", layer.Name)
+
+	default:
+		return fmt.Errorf("layer has unsupported source: %s", layer.Name)
+	}
+
+	*layers = append(*layers, layer)
+	return nil
+}
+
+// fragment is the part of a layer's tags which were
+// read from a single file or environment variable
+type fragment struct {
+	source string
+	tags   Tags
+}
+
+// readLayer reads the fragments of the layer from its
+// source, ordered so that later fragments take precedence
+func (m *Manager) readLayer(layer Layer) ([]fragment, error) {
+
+	logger := m.GetLogger()
+
+	switch layer.Source {
+	case SourceDir:
+
+		// Provider scripts aren't config files
+		skip := map[string]bool{filepath.Clean(m.ExecDir): true}
+
+		return readTagsDir(logger, filepath.SplitList(layer.Path), m.ConfigRecursive, skip)
+
+	case SourceFile:
+		logger.Debug(fmt.Sprintf("reading %s file: %s", layer.Name, layer.Path))
+
+		tags, err := readConfigFile(layer.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		return []fragment{{source: layer.Path, tags: tags}}, nil
+
+	case SourceEnv:
+		return readTagsEnv(layer.Prefix), nil
+
+	case SourceStore:
+
+		// The tags change as the Manager is used, so
+		// their provenance comes from the layer itself
+		tags, err := m.Store.Load(layer.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		return []fragment{{tags: tags}}, nil
+	}
+
+	return nil, fmt.Errorf("layer has unsupported source: %s", layer.Name)
+}
+
+// readTagsEnv returns a fragment for every environment
+// variable which starts with prefix, keyed by the rest
+// of its name in lower case
+func readTagsEnv(prefix string) []fragment {
+
+	var output []fragment
+
+	environ := os.Environ()
+	sort.Strings(environ)
+
+	for _, env := range environ {
+
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, prefix) || name == prefix {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimPrefix(name, prefix))
+		output = append(output, fragment{source: "$" + name, tags: Tags{key: value}})
+	}
+
+	return output
+}
+
+// mergeFragments merges the tags of the fragments in order
+func mergeFragments(fragments []fragment) Tags {
+
+	output := make(Tags)
+
+	for _, frag := range fragments {
+		for key, value := range frag.tags {
+			output[key] = value
+		}
+	}
+
+	return output
+}
+
+// Layers returns the Manager's layers, ordered from
+// the lowest to the highest priority.
+func (m *Manager) Layers() []Layer {
+
+	return append([]Layer(nil), m.layers...)
+}
+
+// LayerTags returns the tags of the layer with the name.
+func (m *Manager) LayerTags(name string) (Tags, error) {
+
+	tags, found := m.tags[name]
+	if !found {
+		return nil, fmt.Errorf("unknown layer: %s", name)
+	}
+
+	return tags, nil
+}
+
+// ConfigDirs returns the directories of the ConfigDir
+// search path, in the order they're read.
+func (m *Manager) ConfigDirs() []string {
+
+	return filepath.SplitList(m.ConfigDir)
+}
@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLockTimeout is returned by Lock when another process
+// holds the lock for longer than the Manager's LockTimeout.
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// How often to check whether the lock has been released
+const lockInterval = 50 * time.Millisecond
+
+// lockFile returns the path of the lock file
+func (m *Manager) lockFile() string {
+	return filepath.Join(m.SystemDir, "systags.lock")
+}
+
+// Lock acquires an exclusive advisory lock on the lock
+// file in the SystemDir, which should be held around the
+// LoadFiles and SaveFiles cycle so concurrent processes
+// don't overwrite each other's changes. It waits up to
+// the Manager's LockTimeout for the lock to be released.
+func (m *Manager) Lock() error {
+
+	if m.lock != nil {
+		return errors.New("lock is already held")
+	}
+
+	path := m.lockFile()
+
+	// The lock file itself is never removed
+	file, err := m.openFile(path, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(m.LockTimeout)
+
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			_ = file.Close()
+			return fmt.Errorf("%w after %s: %s is held by another process", ErrLockTimeout, m.LockTimeout, path)
+		}
+
+		time.Sleep(lockInterval)
+	}
+
+	m.GetLogger().Debug("acquired lock: " + path)
+
+	m.lock = file
+	return nil
+}
+
+// Unlock releases the lock acquired by Lock.
+func (m *Manager) Unlock() error {
+
+	if m.lock == nil {
+		return nil
+	}
+
+	file := m.lock
+	m.lock = nil
+
+	// Closing the file releases the lock as well
+	err := unlockFile(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package manager
+
+import (
+	"os"
+)
+
+// tryLockFile always succeeds on platforms without flock
+func tryLockFile(_ *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile is a no-op on platforms without flock
+func unlockFile(_ *os.File) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package manager
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLockFile attempts to take an exclusive flock on
+// the file without blocking, reporting whether it did
+func tryLockFile(file *os.File) (bool, error) {
+
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// unlockFile releases the flock on the file
+func unlockFile(file *os.File) error {
+
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
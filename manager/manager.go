@@ -1,11 +1,11 @@
 package manager
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -14,22 +14,153 @@ import (
 // Tags describe a map of key/value pairs
 type Tags map[string]string
 
+// MissingKeysError is returned by UpdateRemote in strict
+// mode when required keys are still missing from the
+// remote tags after the retry duration has been reached.
+type MissingKeysError struct {
+	Keys []string
+}
+
+func (e *MissingKeysError) Error() string {
+	return "missing required keys: " + strings.Join(e.Keys, ", ")
+}
+
 // Manager maintains instance information
 // for the type which includes in-memory
 // representations of the tags as well as
 // configurable options.
 type Manager struct {
-	// The directory for the config files
+	// The colon-separated search path of directories
+	// for the config files, where a file masks files
+	// with the same name in earlier directories
 	ConfigDir string
 
+	// Whether subdirectories of the config directories
+	// are read as well, except for the ExecDir
+	ConfigRecursive bool
+
 	// The directory for the system files
 	SystemDir string
 
+	// Comma-separated list of registered providers
+	// to fetch remote tags from, or "auto" to detect
+	// them from the machine which is running systags
+	Provider string
+
+	// The base URL of the EC2 instance metadata
+	// service, uses the SDK default when empty
+	AwsImdsEndpoint string
+
+	// The base URL of the EC2 API, uses the SDK
+	// default for the region when empty
+	AwsEc2Endpoint string
+
+	// Whether to include EC2 instance identity
+	// fields such as the region as remote tags
+	AwsIdentity bool
+
+	// The prefix for EC2 instance identity keys,
+	// where "aws:" is reserved and never used by
+	// user-defined tags
+	AwsNamespace string
+
+	// The base URL of the GCP metadata server
+	GcpEndpoint string
+
+	// The base URL of the Compute Engine API,
+	// used to look up the instance's labels
+	GcpComputeEndpoint string
+
+	// The custom metadata attributes to include as
+	// tags, since others may hold secrets or scripts
+	GcpAttributes []string
+
+	// The base URL of the Azure metadata service
+	AzureEndpoint string
+
+	// The kubeconfig used to reach the Kubernetes
+	// API server, which falls back to the in-cluster
+	// service account or the kubelet's kubeconfig
+	KubeConfig string
+
+	// The name of the Kubernetes node, defaults
+	// to the hostname when left empty
+	KubeNode string
+
+	// The prefix for Kubernetes node label keys
+	KubePrefix string
+
+	// The node annotations to include as tags
+	KubeAnnotations []string
+
+	// The directory of executables which print
+	// remote tags as a JSON object on stdout
+	ExecDir string
+
+	// Where the remote and system tags persist,
+	// defaults to JSON files in the SystemDir
+	Store Store
+
+	// The number of generations of the remote
+	// and system tags to keep for rollbacks
+	HistoryLimit int
+
+	// The permissions given to the files and
+	// directories created in the SystemDir
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// The UID and GID given to the files and
+	// directories created in the SystemDir,
+	// where -1 leaves them to the process
+	Owner int
+	Group int
+
+	// Whether entries of the audit journal are
+	// hash chained so that edits are detectable
+	AuditChain bool
+
+	// How long Lock waits for another process
+	// to release the lock before giving up
+	LockTimeout time.Duration
+
+	// Whether LoadFiles fails instead of warning
+	// when files of a layer define the same key,
+	// or when templates are invalid or cyclic
+	StrictConfig bool
+
+	// Whether UpdateRemote fails, keeping the
+	// previous remote tags, when required keys
+	// never arrive within the retry duration
+	StrictRemote bool
+
+	// Whether UpdateRemote fails, keeping the
+	// previous remote tags, when the fetched
+	// tags violate the schema
+	ValidateRemote bool
+
 	logger *slog.Logger
 
-	config Tags
-	remote Tags
-	system Tags
+	// Ordered from the lowest priority
+	layers    []Layer
+	tags      map[string]Tags
+	fragments map[string][]fragment
+
+	schema *Schema
+	policy *Policy
+
+	status RemoteStatus
+	audit  []AuditEntry
+
+	// Whether the status was inferred from the remote
+	// file and has to be kept before it's rewritten
+	statusInferred bool
+
+	// The timeout of the fetch in progress, which
+	// providers may give to each of their requests
+	fetchTimeout time.Duration
+
+	lock *os.File
 }
 
 // NewManager initializes a new Manager with
@@ -42,10 +173,26 @@ func NewManager() *Manager {
 	// SystemDir will need to be changed.
 
 	m := Manager{
-		ConfigDir: "/etc/systags.d",
+		ConfigDir: "/usr/lib/systags.d:/etc/systags.d:/run/systags.d",
 		SystemDir: "/var/lib/systags",
+
+		Provider:           "auto",
+		AwsNamespace:       "aws:",
+		GcpEndpoint:        GcpMetadataEndpoint,
+		GcpComputeEndpoint: GcpComputeEndpoint,
+		AzureEndpoint:      AzureMetadataEndpoint,
+		ExecDir:            ExecProviderDir,
+		LockTimeout:        10 * time.Second,
+		HistoryLimit:       10,
+		FileMode:           0644,
+		DirMode:            0755,
+		Owner:              -1,
+		Group:              -1,
 	}
 
+	m.Store = NewFileStore(&m)
+	m.layers = m.DefaultLayers()
+
 	m.SetLogger(nil)
 	m.Reset()
 
@@ -70,221 +217,186 @@ func (m *Manager) SetLogger(l *slog.Logger) {
 }
 
 // Reset clears the current in-memory representation
-// of this Manager's config, remote, and system tags.
+// of the tags of every one of this Manager's layers.
 func (m *Manager) Reset() {
 
-	m.config = make(Tags)
-	m.remote = make(Tags)
-	m.system = make(Tags)
+	m.recordAuditDiff("reset", "remote", m.tags["remote"], nil)
+	m.recordAuditDiff("reset", "system", m.tags["system"], nil)
+
+	m.fragments = nil
+	m.tags = make(map[string]Tags)
+	for _, layer := range m.layers {
+		m.tags[layer.Name] = make(Tags)
+	}
 }
 
-// LoadFiles reads files from the Manager's ConfigDir
-// and SystemDir and stores their contents in the
-// Manager's internal config, remote, and system tags.
+// LoadFiles reads the layers defined in the ConfigDir
+// and the tags of every layer from its source, such as
+// the config files in the ConfigDir and the remote and
+// system tags in the Store. Corrupt files in the
+// SystemDir are recovered from their backups when
+// possible.
 func (m *Manager) LoadFiles() error {
 
-	logger := m.GetLogger()
-
-	configData := make(Tags)
-	remoteData := make(Tags)
-	systemData := make(Tags)
-
-	// Try to get all files in config directory
-	configFiles, err := os.ReadDir(m.ConfigDir)
-
-	if err == nil {
-
-		logger.Debug("reading config directory: " + m.ConfigDir)
-
-		// Iterate through all config files
-		for _, file := range configFiles {
-
-			// Ignore folders
-			if file.IsDir() {
-				continue
-			}
-
-			// Ignore files which aren't JSON
-			ext := filepath.Ext(file.Name())
-			if ext != ".json" {
-				continue
-			}
-
-			// Construct the full path to the current config file
-			configFile := filepath.Join(m.ConfigDir, file.Name())
-
-			logger.Debug(configFile)
-
-			// Attempt to read the contents of the file
-			configBytes, err := os.ReadFile(configFile)
-			if err != nil {
-				return err
-			}
-
-			configJson := make(Tags)
-			// Try and parse the file as a Tag JSON object
-			err = json.Unmarshal(configBytes, &configJson)
-			if err != nil {
-				return err
-			}
+	layers, err := m.loadLayers()
+	if err != nil {
+		return err
+	}
 
-			// Merge latest config into result
-			for key, value := range configJson {
-				configData[key] = value
-			}
-		}
+	schema, err := m.loadSchema()
+	if err != nil {
+		return err
 	}
 
-	// Construct the full path to the system directory files
-	remoteFile := filepath.Join(m.SystemDir, "remote.json")
-	systemFile := filepath.Join(m.SystemDir, "system.json")
+	policy, err := m.loadPolicy(layers)
+	if err != nil {
+		return err
+	}
 
-	// Check if remote file exists and then read it
-	if _, err := os.Stat(remoteFile); err == nil {
+	tags := make(map[string]Tags)
+	fragments := make(map[string][]fragment)
 
-		logger.Debug("reading remote file: " + remoteFile)
+	for _, layer := range layers {
 
-		// Attempt to read the contents of the file
-		remoteBytes, err := os.ReadFile(remoteFile)
+		// Attempt to read the tags of the layer
+		layerData, err := m.readLayer(layer)
 		if err != nil {
 			return err
 		}
 
-		// Try and parse the file as a Tag JSON object
-		err = json.Unmarshal(remoteBytes, &remoteData)
-		if err != nil {
-			return err
+		tags[layer.Name] = mergeFragments(layerData)
+
+		// Only read-only sources keep their fragments
+		if layer.Source != SourceStore {
+			fragments[layer.Name] = layerData
 		}
 	}
 
-	// Check if system file exists and then read it
-	if _, err := os.Stat(systemFile); err == nil {
+	// Other users shouldn't be able to change the tags
+	m.warnWritable()
 
-		logger.Debug("reading system file: " + systemFile)
+	m.layers = layers
+	m.tags = tags
+	m.fragments = fragments
+	m.schema = schema
+	m.policy = policy
 
-		// Attempt to read the contents of the file
-		systemBytes, err := os.ReadFile(systemFile)
-		if err != nil {
-			return err
-		}
+	// Later files silently winning is easy to miss
+	err = m.checkConflicts()
+	if err != nil {
+		return err
+	}
 
-		// Try and parse the file as a Tag JSON object
-		err = json.Unmarshal(systemBytes, &systemData)
-		if err != nil {
-			return err
-		}
+	// Catch broken templates before they're used
+	err = m.checkTemplates()
+	if err != nil {
+		return err
 	}
 
-	m.config = configData
-	m.remote = remoteData
-	m.system = systemData
+	// Changes to the previous state no longer apply
+	m.audit = nil
 
-	return nil
+	return m.loadStatus()
 }
 
 // SaveFiles saves the current state of the Manager's
-// remote and system tags to corresponding files in
-// the SystemDir. Before writing new data, it attempts
-// to create a backup of the existing files.
+// remote and system tags to its Store in a single
+// transaction. The default file Store keeps them in
+// the SystemDir, along with backups and up to the
+// HistoryLimit generations of them. Changes made since
+// the last save are then appended to the audit journal.
 func (m *Manager) SaveFiles() error {
 
-	logger := m.GetLogger()
-
-	// Construct the full path to the system directory files
-	remoteFile := filepath.Join(m.SystemDir, "remote.json")
-	systemFile := filepath.Join(m.SystemDir, "system.json")
+	// Saving rewrites the remote file, so persist
+	// the age inferred from it while it's still valid
+	if m.statusInferred {
+		if err := m.SaveStatus(); err != nil {
+			return err
+		}
+	}
 
-	// Attempt to convert the remote data to JSON
-	remoteJson, err := json.MarshalIndent(m.remote, "", "\t")
+	err := m.Store.Save(map[string]Tags{
+		"remote": m.tags["remote"],
+		"system": m.tags["system"],
+	})
 	if err != nil {
 		return err
 	}
 
-	// Attempt to convert the system data to JSON
-	systemJson, err := json.MarshalIndent(m.system, "", "\t")
+	// Record who changed what now that it's persisted
+	return m.flushAudit()
+}
+
+// RemoteFetch holds the outcome of FetchRemote until
+// it's applied to the Manager's tags by ApplyRemote.
+type RemoteFetch struct {
+	providers []Provider
+	tags      Tags
+	err       error
+}
+
+// FetchRemote fetches remote tags from the instance
+// it's currently running on using the providers set
+// by the Provider field, without changing the Manager's
+// tags, so no lock needs to be held while it runs. This
+// operation may take some time to complete, controlled
+// by timeout. If a retry duration is provided, this
+// function will auto retry for the duration if empty
+// tags are returned. A bounded exponential backoff
+// strategy is employed. If the required keys slice is
+// provided, the function will keep retrying until all
+// the required keys are present in the fetched tags or
+// until the retry duration is reached. In strict mode,
+// a MissingKeysError is kept when required keys are
+// still missing after retrying.
+func (m *Manager) FetchRemote(timeout time.Duration, retry time.Duration, requiredKeys []string) *RemoteFetch {
+
+	m.fetchTimeout = timeout
+
+	providers, err := m.resolveProviders(timeout)
 	if err != nil {
-		return err
+		return &RemoteFetch{err: err}
 	}
 
-	// Check if remote file exists and then read it
-	if _, err := os.Stat(remoteFile); err == nil {
+	fetch := func() (Tags, error) {
 
-		remoteBackup := remoteFile + ".bak"
+		// Don't wait too long for API calls
+		ctx, cancel := context.WithTimeout(
+			context.Background(), timeout,
+		)
+		defer cancel()
 
-		logger.Debug("writing remote backup: " + remoteBackup)
+		var partialErr error
 
-		// Attempt to read the contents of the file
-		remoteBytes, err := os.ReadFile(remoteFile)
-		if err != nil {
-			return err
-		}
+		output := make(Tags)
+		// Merge tags from every provider in order
+		for _, provider := range providers {
 
-		// Try and backup the contents of the file
-		err = os.WriteFile(remoteBackup, remoteBytes, 0666)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Check if system file exists and then read it
-	if _, err := os.Stat(systemFile); err == nil {
+			tags, err := provider.Fetch(ctx)
+			if err != nil {
 
-		systemBackup := systemFile + ".bak"
+				var partial *PartialTagsError
+				if !errors.As(err, &partial) {
+					return nil, fmt.Errorf("%s: %w", provider.Name(), err)
+				}
 
-		logger.Debug("writing system backup: " + systemBackup)
+				// Keep going so the caller gets everything
+				partialErr = fmt.Errorf("%s: %w", provider.Name(), partial.Err)
+				tags = partial.Tags
+			}
 
-		// Attempt to read the contents of the file
-		systemBytes, err := os.ReadFile(systemFile)
-		if err != nil {
-			return err
+			for key, value := range tags {
+				output[key] = value
+			}
 		}
 
-		// Try and backup the contents of the file
-		err = os.WriteFile(systemBackup, systemBytes, 0666)
-		if err != nil {
-			return err
+		if partialErr != nil {
+			return nil, &PartialTagsError{Tags: output, Err: partialErr}
 		}
-	}
-
-	logger.Debug("writing remote file: " + remoteFile)
-
-	// Attempt to write the current tag content
-	err = os.WriteFile(remoteFile, remoteJson, 0666)
-	if err != nil {
-		return err
-	}
-
-	logger.Debug("writing system file: " + systemFile)
 
-	// Attempt to write the current tag content
-	err = os.WriteFile(systemFile, systemJson, 0666)
-	if err != nil {
-		return err
+		return output, nil
 	}
 
-	return nil
-}
-
-// UpdateRemote fetches remote tags from the instance
-// it's currently running on. This operation may take
-// some time to complete, controlled by timeout. If a
-// retry duration is provided, this function will auto
-// retry for the duration if empty tags are returned.
-// A bounded exponential backoff strategy is employed.
-// If the required keys slice is provided, the function
-// will keep retrying until all the required keys are
-// present in the fetched tags or until the retry
-// duration is reached.
-func (m *Manager) UpdateRemote(timeout time.Duration, retry time.Duration, requiredKeys []string) error {
-
-	// TODO:
-	// At the moment, only AWS is supported, but if you want
-	// to support GCP or other cloud providers in the future
-	// then you will need to implement a function to detect
-	// which cloud provider is being used and add the feature
-	// to update the tags similar to how it's done now in AWS.
-
-	var err error
 	var res Tags
 
 	// Sleep duration to start with
@@ -296,30 +408,45 @@ func (m *Manager) UpdateRemote(timeout time.Duration, retry time.Duration, requi
 	startTime := time.Now()
 	untilTime := startTime.Add(retry)
 
-	hasRequiredKeys := func(tags Tags) bool {
+	missingKeys := func(tags Tags) []string {
 
+		var missing []string
 		for _, k := range requiredKeys {
 			if _, ok := tags[k]; !ok {
-				return false
+				missing = append(missing, k)
 			}
 		}
 
-		return true
+		return missing
 	}
 
 	for {
-		res, err = getAwsTags(m.GetLogger(), timeout)
+		res, err = fetch()
 		if err != nil {
-			return err
+
+			// Whether incomplete tags are usable is up to ApplyRemote
+			var partial *PartialTagsError
+			if !errors.As(err, &partial) {
+				return &RemoteFetch{providers: providers, err: err}
+			}
+
+			res = partial.Tags
 		}
 
-		// Check whether all the required keys have values
-		if len(requiredKeys) > 0 && hasRequiredKeys(res) {
+		if len(requiredKeys) > 0 {
+
+			// Check whether all the required keys have values
+			if len(missingKeys(res)) == 0 {
+				break
+			}
+
+		} else if len(res) > 0 {
+			// Tags are not empty
 			break
 		}
-    
-		// Tags are not empty or we have reached time limit
-		if len(res) > 0 || time.Since(startTime) > retry {
+
+		// We have reached time limit
+		if time.Since(startTime) > retry {
 			break
 		}
 
@@ -338,33 +465,89 @@ func (m *Manager) UpdateRemote(timeout time.Duration, retry time.Duration, requi
 		}
 	}
 
-	// TODO: Should we return an error if required keys and len(res) condition not met?
+	// Don't replace the remote tags with an incomplete set
+	if missing := missingKeys(res); m.StrictRemote && len(missing) > 0 {
+		err = &MissingKeysError{Keys: missing}
+	}
+
+	return &RemoteFetch{providers: providers, tags: res, err: err}
+}
+
+// ApplyRemote replaces the Manager's remote tags with
+// the outcome of FetchRemote, which should happen after
+// the files have been loaded under the lock. If a provider
+// only returned some of its tags, the PartialTagsError
+// is returned and the previous remote tags are kept,
+// unless there were none, in which case the partial tags
+// are used and the status is recorded as partial. With
+// ValidateRemote, a SchemaError is returned when values
+// of the fetched tags violate the schema. The outcome
+// is recorded in the Manager's RemoteStatus.
+func (m *Manager) ApplyRemote(fetch *RemoteFetch) (err error) {
+
+	var partialErr error
+
+	// Keep track of the outcome, successful or not
+	defer func() {
+		m.recordStatus(fetch.providers, partialErr, err)
+	}()
+
+	if fetch.err != nil {
+
+		// Incomplete tags are only better than nothing
+		var partial *PartialTagsError
+		if !errors.As(fetch.err, &partial) || len(m.tags["remote"]) > 0 {
+			return fetch.err
+		}
+
+		m.GetLogger().Warn(fetch.err.Error())
+		partialErr = fetch.err
+	}
+
+	// Required keys may come from other layers, so only
+	// the values of the remote tags are checked
+	if violations := m.schema.ValidateValues(fetch.tags); m.ValidateRemote && len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
 
-	m.remote = res
+	m.recordAuditDiff("update", "remote", m.tags["remote"], fetch.tags)
+
+	m.tags["remote"] = fetch.tags
 	return nil
 }
 
+// UpdateRemote fetches remote tags with FetchRemote and
+// applies them right away with ApplyRemote. Callers that
+// hold a lock should use those separately instead, so the
+// lock isn't held while waiting on the network.
+func (m *Manager) UpdateRemote(timeout time.Duration, retry time.Duration, requiredKeys []string) error {
+
+	return m.ApplyRemote(m.FetchRemote(timeout, retry, requiredKeys))
+}
+
 // ConfigTags returns the Manager's config tags.
 func (m *Manager) ConfigTags() Tags {
 
-	return m.config
+	return m.tags["config"]
 }
 
 // RemoteTags returns the Manager's remote tags.
 func (m *Manager) RemoteTags() Tags {
 
-	return m.remote
+	return m.tags["remote"]
 }
 
 // SystemTags returns the Manager's system tags.
 func (m *Manager) SystemTags() Tags {
 
-	return m.system
+	return m.tags["system"]
 }
 
-// GetTags returns the combined set of config, system,
-// and remote tags, but it filters the tags based on
-// regular expressions provided in the "pick" and "omit"
+// GetTags returns the combined tags of every layer, with
+// higher priority layers taking precedence, such as system
+// over config over remote, and with templated values from
+// config files expanded. It filters the tags based
+// on regular expressions provided in the "pick" and "omit"
 // parameters. If the "regex" parameter is set to false,
 // the function treats the "pick" and "omit" parameters
 // as comma-separated lists of exact keys to include or
@@ -402,22 +585,7 @@ func (m *Manager) GetTags(
 	pickRegex := regexp.MustCompile(pick)
 	omitRegex := regexp.MustCompile(omit)
 
-	combined := make(Tags)
-
-	// Merge remote tags into combined
-	for key, value := range m.remote {
-		combined[key] = value
-	}
-
-	// Merge config tags into combined
-	for key, value := range m.config {
-		combined[key] = value
-	}
-
-	// Merge system tags into combined
-	for key, value := range m.system {
-		combined[key] = value
-	}
+	combined := m.effectiveTags()
 
 	picked := make(Tags)
 	omited := make(Tags)
@@ -457,56 +625,78 @@ func (m *Manager) GetTags(
 	return omited
 }
 
-// GetTag returns a tag by its key from system, config,
-// or remote tags, in that order. If the key doesn't exist
-// in any of the tag sets, it returns the default value.
+// GetTag returns a tag by its key from the layer with the
+// highest priority which has it, such as system, config,
+// or remote, expanding it if it's templated. If the key
+// doesn't exist in any of the layers, it returns the
+// default value.
 func (m *Manager) GetTag(key string, def string) string {
 
-	// Whether system has the key
-	value, found := m.system[key]
-	if found {
-		return value
-	}
+	r := m.newResolver()
 
-	// Whether config has the key
-	value, found = m.config[key]
-	if found {
-		return value
+	value, found := r.tags[key]
+	if !found {
+		return def
 	}
 
-	// Whether remote has the key
-	value, found = m.remote[key]
-	if found {
+	// Fall back to the value as is, like GetTags
+	resolved, err := r.resolve(key)
+	if err != nil {
+		m.GetLogger().Warn(err.Error())
 		return value
 	}
 
-	return def
+	return resolved
 }
 
 // SetTag sets a tag with the specified key and value in
 // the system tags. It returns the previous value of the
-// tag, or an empty string if the tag did not exist.
-func (m *Manager) SetTag(key string, val string) string {
+// tag, or an empty string if the tag did not exist. The
+// tag is left alone if it's locked to another layer or
+// the value violates the schema.
+func (m *Manager) SetTag(key string, val string) (string, error) {
 
 	// Retrieve the current value
-	existing, _ := m.system[key]
+	existing, _ := m.tags["system"][key]
+
+	err := m.policy.checkChange(key, "system")
+	if err != nil {
+		return existing, err
+	}
+
+	err = m.schema.ValidateValue(key, val)
+	if err != nil {
+		return existing, err
+	}
+
+	m.recordAudit("set", "system", key, lookupTag(m.tags["system"], key), &val)
 
 	// Apply new value
-	m.system[key] = val
-	return existing
+	m.tags["system"][key] = val
+	return existing, nil
 }
 
 // RemoveTag removes a tag with the specified key from
 // the system tags. It returns the previous value of the
-// tag, or an empty string if the tag did not exist.
-func (m *Manager) RemoveTag(key string) string {
+// tag, or an empty string if the tag did not exist. The
+// tag is left alone if it's locked to another layer.
+func (m *Manager) RemoveTag(key string) (string, error) {
 
 	// Retrieve the current value
-	existing, _ := m.system[key]
+	existing, _ := m.tags["system"][key]
+
+	err := m.policy.checkChange(key, "system")
+	if err != nil {
+		return existing, err
+	}
+
+	if _, found := m.tags["system"][key]; found {
+		m.recordAudit("rm", "system", key, &existing, nil)
+	}
 
 	// Delete the value
-	delete(m.system, key)
-	return existing
+	delete(m.tags["system"], key)
+	return existing, nil
 }
 
 // PrefixTags returns new tags based on the specified
@@ -0,0 +1,390 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseError describes why a config file couldn't be
+// parsed, along with where it happened when known.
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// lineError is returned by parsers for errors at a line
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return e.err.Error()
+}
+
+// lineAt returns the line number of offset in data
+func lineAt(data []byte, offset int64) int {
+
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// ParseJson attempts to parse a JSON object of string
+// values into tags.
+func ParseJson(data []byte) (Tags, error) {
+
+	tags := make(Tags)
+
+	// Try and parse the data as a Tag JSON object
+	err := json.Unmarshal(data, &tags)
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return nil, &lineError{line: lineAt(data, syntaxErr.Offset), err: err}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return nil, &lineError{line: lineAt(data, typeErr.Offset), err: err}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// yamlLine finds the line in yaml.v3 error messages
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// ParseYaml attempts to parse a YAML mapping of scalar
+// values into tags. Scalars are kept as they're written.
+func ParseYaml(data []byte) (Tags, error) {
+
+	var doc yaml.Node
+
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+
+		// Only the first error is reported
+		msg := strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n  ")
+		msg = strings.SplitN(msg, "\n", 2)[0]
+
+		if match := yamlLine.FindStringSubmatch(msg); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			return nil, &lineError{line: line, err: errors.New(msg[len(match[0]):])}
+		}
+
+		return nil, errors.New(msg)
+	}
+
+	tags := make(Tags)
+
+	// Empty files have no content at all
+	if len(doc.Content) == 0 {
+		return tags, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &lineError{line: root.Line, err: errors.New("expected a mapping")}
+	}
+
+	// The line where each key was first defined
+	lines := make(map[string]int)
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+
+		key := root.Content[i]
+		value := root.Content[i+1]
+
+		if key.Kind != yaml.ScalarNode {
+			return nil, &lineError{line: key.Line, err: errors.New("unsupported key")}
+		}
+
+		// Decoding through nodes would let the last one win
+		if line, found := lines[key.Value]; found {
+			return nil, &lineError{
+				line: key.Line,
+				err:  fmt.Errorf("duplicate key: %s (first defined on line %d)", key.Value, line),
+			}
+		}
+		lines[key.Value] = key.Line
+
+		// Only scalar values can be used as tags
+		if value.Kind != yaml.ScalarNode || value.Tag == "!!null" {
+			return nil, &lineError{line: value.Line, err: fmt.Errorf("unsupported value for key: %s", key.Value)}
+		}
+
+		tags[key.Value] = value.Value
+	}
+
+	return tags, nil
+}
+
+// ParseToml attempts to parse a TOML document of top
+// level scalar values into tags.
+func ParseToml(data []byte) (Tags, error) {
+
+	var object map[string]interface{}
+
+	err := toml.Unmarshal(data, &object)
+
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		line, _ := decodeErr.Position()
+		return nil, &lineError{line: line, err: err}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var lines map[string]int
+	var unsupported *lineError
+
+	tags := make(Tags)
+	// Only scalar values can be used as tags
+	for key, value := range object {
+
+		switch v := value.(type) {
+		case string:
+			tags[key] = v
+
+		case int64, float64, bool:
+			tags[key] = fmt.Sprint(v)
+
+		default:
+			if lines == nil {
+				lines = tomlKeyLines(data)
+			}
+
+			// Report the first one in the document
+			line := lines[key]
+			if unsupported == nil || line < unsupported.line {
+				unsupported = &lineError{
+					line: line,
+					err:  fmt.Errorf("unsupported value for key: %s", key),
+				}
+			}
+		}
+	}
+
+	if unsupported != nil {
+		return nil, unsupported
+	}
+
+	return tags, nil
+}
+
+// tomlKeyLines returns the line where each top level
+// key of a valid TOML document is first defined, either
+// by a key/value pair or by a table header.
+func tomlKeyLines(data []byte) map[string]int {
+
+	lines := make(map[string]int)
+
+	parser := unstable.Parser{}
+	parser.Reset(data)
+
+	// Pairs after a table header belong to that table
+	inTable := false
+
+	for parser.NextExpression() {
+
+		expr := parser.Expression()
+
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			inTable = true
+
+		case unstable.KeyValue:
+			if inTable {
+				continue
+			}
+
+		default:
+			continue
+		}
+
+		// Only the first part of a dotted key is top level
+		keys := expr.Key()
+		if !keys.Next() {
+			continue
+		}
+
+		key := keys.Node()
+		if _, found := lines[string(key.Data)]; !found {
+			lines[string(key.Data)] = parser.Shape(key.Raw).Start.Line
+		}
+	}
+
+	return lines
+}
+
+// ParseEnv attempts to parse dotenv lines of the form
+// KEY=value into tags, where keys are kept as they're
+// written. Lines may start with export, and blank lines
+// or lines starting with # are ignored. Single quoted
+// values are literal, double quoted values support the
+// \n, \t, \", and \\ escapes, and unquoted values end
+// at a # preceded by a space.
+func ParseEnv(data []byte) (Tags, error) {
+
+	tags := make(Tags)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		text = strings.TrimPrefix(text, "export ")
+
+		key, value, found := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, &lineError{line: line, err: errors.New("expected KEY=value")}
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, &lineError{line: line, err: err}
+		}
+
+		tags[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// parseEnvValue unquotes a single dotenv value
+func parseEnvValue(value string) (string, error) {
+
+	if value == "" {
+		return "", nil
+	}
+
+	switch value[0] {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated quote")
+		}
+
+		if rest := strings.TrimSpace(value[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", errors.New("unexpected text after quote")
+		}
+
+		return value[1 : end+1], nil
+
+	case '"':
+		var out strings.Builder
+
+		for i := 1; i < len(value); i++ {
+
+			c := value[i]
+			if c == '"' {
+
+				if rest := strings.TrimSpace(value[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+					return "", errors.New("unexpected text after quote")
+				}
+
+				return out.String(), nil
+			}
+
+			if c == '\\' && i+1 < len(value) {
+				i++
+
+				switch value[i] {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				default:
+					c = value[i]
+				}
+			}
+
+			out.WriteByte(c)
+		}
+
+		return "", errors.New("unterminated quote")
+	}
+
+	// Inline comments need a space before them
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+
+	return strings.TrimSpace(value), nil
+}
+
+// Parse is a type that defines a function signature
+// for parsing a config file into tags.
+type Parse func([]byte) (Tags, error)
+
+// Parsers is a registry of config file parsing
+// functions by file extension.
+var Parsers = map[string]Parse{
+	".json": ParseJson,
+	".yaml": ParseYaml,
+	".yml":  ParseYaml,
+	".toml": ParseToml,
+	".env":  ParseEnv,
+}
+
+// parseFile parses the config file using the parser of
+// its extension, wrapping errors with file and line
+func parseFile(path string, data []byte, parse Parse) (Tags, error) {
+
+	tags, err := parse(data)
+	if err == nil {
+		return tags, nil
+	}
+
+	var lineErr *lineError
+	if errors.As(err, &lineErr) {
+		return nil, &ParseError{File: path, Line: lineErr.line, Err: lineErr.err}
+	}
+
+	return nil, &ParseError{File: path, Err: err}
+}
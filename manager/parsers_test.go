@@ -0,0 +1,33 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseYamlDuplicateKey(t *testing.T) {
+
+	data := "a: 1\nb: 2\na: 3\n"
+
+	_, err := parseFile("tags.yaml", []byte(data), ParseYaml)
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("got error %v, want ParseError", err)
+	}
+
+	want := "tags.yaml:3: duplicate key: a (first defined on line 1)"
+	if err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
+
+func TestParseYaml(t *testing.T) {
+
+	tags, err := ParseYaml([]byte("env: prod\nport: 8080\nnote: \"a: b\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTags(t, tags, Tags{"env": "prod", "port": "8080", "note": "a: b"})
+}
@@ -0,0 +1,182 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// fileAttrs describe the mode and ownership given to
+// the files and directories created in the SystemDir
+type fileAttrs struct {
+	perm os.FileMode
+	uid  int
+	gid  int
+}
+
+// fileAttrs returns the attributes for new files
+func (m *Manager) fileAttrs() fileAttrs {
+	return fileAttrs{perm: m.FileMode, uid: m.Owner, gid: m.Group}
+}
+
+// dirAttrs returns the attributes for new directories
+func (m *Manager) dirAttrs() fileAttrs {
+	return fileAttrs{perm: m.DirMode, uid: m.Owner, gid: m.Group}
+}
+
+// applyAttrs sets the mode of the file, which unlike the
+// mode passed when creating it isn't masked by the umask,
+// and changes its owner and group when they're set
+func applyAttrs(path string, attrs fileAttrs) error {
+
+	err := os.Chmod(path, attrs.perm)
+	if err != nil {
+		return err
+	}
+
+	if attrs.uid < 0 && attrs.gid < 0 {
+		return nil
+	}
+
+	return os.Chown(path, attrs.uid, attrs.gid)
+}
+
+// openFile opens the file in the SystemDir for writing,
+// creating it with the Manager's file attributes if it
+// doesn't exist yet
+func (m *Manager) openFile(path string, flag int) (*os.File, error) {
+
+	_, statErr := os.Stat(path)
+
+	file, err := os.OpenFile(path, flag|os.O_CREATE, m.FileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave the attributes of existing files alone
+	if errors.Is(statErr, os.ErrNotExist) {
+
+		err = applyAttrs(path, m.fileAttrs())
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// makeDir creates the directory along with any missing
+// parents, giving the directory itself the Manager's
+// directory attributes if it doesn't exist yet
+func (m *Manager) makeDir(dir string) error {
+
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, m.DirMode)
+	if err != nil {
+		return err
+	}
+
+	return applyAttrs(dir, m.dirAttrs())
+}
+
+// CreateSystemDir creates the SystemDir using the
+// Manager's DirMode, Owner and Group if it's missing.
+func (m *Manager) CreateSystemDir() error {
+
+	m.GetLogger().Debug("creating system directory: " + m.SystemDir)
+
+	return m.makeDir(m.SystemDir)
+}
+
+// warnWritable logs a warning for the SystemDir and the
+// files in it which any user is allowed to write to
+func (m *Manager) warnWritable() {
+
+	paths := []string{
+		m.SystemDir,
+		filepath.Join(m.SystemDir, "systags.db"),
+		m.statusFile(),
+		m.auditFile(),
+		m.lockFile(),
+	}
+
+	for _, name := range []string{"remote.json", "system.json"} {
+		path := filepath.Join(m.SystemDir, name)
+		paths = append(paths, path, path+".bak")
+	}
+
+	// Generations can be rolled back to, so
+	// they're as sensitive as the current files
+	_ = filepath.WalkDir(filepath.Join(m.SystemDir, "history"), func(path string, entry fs.DirEntry, err error) error {
+		if err == nil {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	for _, path := range paths {
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		// Sticky directories such as /tmp are fine
+		if info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+			continue
+		}
+
+		if info.Mode().Perm()&0002 != 0 {
+			m.GetLogger().Warn(fmt.Sprintf("%s is world-writable (%04o)", path, info.Mode().Perm()))
+		}
+	}
+}
+
+// ParseMode parses an octal permission mode such as 0640.
+func ParseMode(value string) (os.FileMode, error) {
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode: %s", value)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// LookupOwner resolves a user name or numeric ID to a UID.
+func LookupOwner(name string) (int, error) {
+
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(u.Uid)
+}
+
+// LookupGroup resolves a group name or numeric ID to a GID.
+func LookupGroup(name string) (int, error) {
+
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWarnWritable(t *testing.T) {
+
+	m := NewManager()
+	m.SystemDir = t.TempDir()
+
+	var logs bytes.Buffer
+	m.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	generation := filepath.Join(m.SystemDir, "history", "20240101T000000.000000000Z")
+	if err := os.MkdirAll(generation, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{
+		m.statusFile(),
+		m.auditFile(),
+		m.lockFile(),
+		filepath.Join(generation, "remote.json"),
+	}
+
+	for _, path := range paths {
+		writeTestFile(t, path, "{}")
+
+		// Not affected by the umask unlike WriteFile
+		if err := os.Chmod(path, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.warnWritable()
+
+	for _, path := range paths {
+		if !strings.Contains(logs.String(), path+" is world-writable") {
+			t.Errorf("%s wasn't warned about:\n%s", path, logs.String())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+// PolicyFile is the optional file in the ConfigDir which
+// locks keys to layers, it's never read as config tags.
+// Like config files, the one in the last directory is used.
+const PolicyFile = "policy.json"
+
+// Policy locks keys, or shell patterns of keys such as
+// "aws:*", to the layer they must come from. Values of
+// locked keys from any other layer are ignored, and the
+// keys can't be set or removed unless locked to system.
+type Policy struct {
+	Locked map[string]string `json:"locked"`
+
+	// Sorted so matching is deterministic
+	patterns []string
+}
+
+// LockedKeyError is returned when changing a tag which
+// is locked to another layer.
+type LockedKeyError struct {
+	Key   string
+	Layer string
+}
+
+func (e *LockedKeyError) Error() string {
+	return fmt.Sprintf("tag %s is locked to the %s layer", e.Key, e.Layer)
+}
+
+// compile checks the policy against the layers and
+// prepares its patterns
+func (p *Policy) compile(layers []Layer) error {
+
+	names := make(map[string]bool)
+	for _, layer := range layers {
+		names[layer.Name] = true
+	}
+
+	for pattern, layer := range p.Locked {
+
+		if !names[layer] {
+			return fmt.Errorf("key %s: unknown layer: %s", pattern, layer)
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("key %s: %w", pattern, err)
+		}
+
+		p.patterns = append(p.patterns, pattern)
+	}
+
+	sort.Strings(p.patterns)
+	return nil
+}
+
+// LockedLayer returns the layer the key is locked to.
+// Keys take precedence over patterns, which are matched
+// in lexical order. It returns false if it isn't locked.
+func (p *Policy) LockedLayer(key string) (string, bool) {
+
+	if p == nil {
+		return "", false
+	}
+
+	if layer, found := p.Locked[key]; found {
+		return layer, true
+	}
+
+	for _, pattern := range p.patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return p.Locked[pattern], true
+		}
+	}
+
+	return "", false
+}
+
+// allows reports whether a value of the key from the
+// layer is used when merging
+func (p *Policy) allows(key string, layer string) bool {
+
+	locked, found := p.LockedLayer(key)
+	return !found || locked == layer
+}
+
+// checkChange fails if the key can't be changed in the layer
+func (p *Policy) checkChange(key string, layer string) error {
+
+	if locked, found := p.LockedLayer(key); found && locked != layer {
+		return &LockedKeyError{Key: key, Layer: locked}
+	}
+
+	return nil
+}
+
+// loadPolicy reads the PolicyFile from the ConfigDir,
+// resulting in nil when there is none
+func (m *Manager) loadPolicy(layers []Layer) (*Policy, error) {
+
+	policyFile := findConfigFile(m.ConfigDirs(), PolicyFile)
+	if policyFile == "" {
+		return nil, nil
+	}
+
+	m.GetLogger().Debug("reading policy file: " + policyFile)
+
+	// Attempt to read the contents of the file
+	data, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	// Try and parse the file as a policy JSON object
+	err = json.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", policyFile, err)
+	}
+
+	err = policy.compile(layers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", policyFile, err)
+	}
+
+	return policy, nil
+}
+
+// Policy returns the policy from the ConfigDir, or nil
+// when there is none.
+func (m *Manager) Policy() *Policy {
+
+	return m.policy
+}
@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Provider describes a source of remote tags, such
+// as the metadata service of a cloud provider.
+type Provider interface {
+	// Name returns the name the provider is registered under
+	Name() string
+
+	// Detect reports whether the provider is available
+	Detect(ctx context.Context) bool
+
+	// Fetch retrieves the remote tags from the provider
+	Fetch(ctx context.Context) (Tags, error)
+}
+
+// PartialTagsError is returned by a Provider when only
+// some of the remote tags could be retrieved, which means
+// the tags it carries should not be trusted to be complete.
+type PartialTagsError struct {
+	Tags Tags
+	Err  error
+}
+
+func (e *PartialTagsError) Error() string {
+	return "partial remote tags: " + e.Err.Error()
+}
+
+func (e *PartialTagsError) Unwrap() error {
+	return e.Err
+}
+
+// NewProvider is a type that defines a function signature
+// for creating a provider configured by the Manager.
+type NewProvider func(*Manager) Provider
+
+// Providers is a registry of remote tag providers.
+var Providers = map[string]NewProvider{
+	"aws":        NewAwsProvider,
+	"gcp":        NewGcpProvider,
+	"azure":      NewAzureProvider,
+	"kubernetes": NewKubeProvider,
+	"exec":       NewExecProvider,
+}
+
+// DetectOrder lists the cloud providers in the order
+// they are tried when the provider is "auto", where the
+// first one which detects itself is used. Providers such
+// as kubernetes and exec are only used when listed.
+var DetectOrder = []string{
+	"aws",
+	"gcp",
+	"azure",
+}
+
+// dmiDetector is implemented by providers which can
+// detect themselves from DMI data alone, which is
+// checked for every provider before any network probe
+type dmiDetector interface {
+	detectDmi() bool
+}
+
+// DmiDir is the directory exposing DMI/SMBIOS data.
+var DmiDir = "/sys/class/dmi/id"
+
+// Maximum duration to spend probing a metadata service
+const detectTimeout = 2 * time.Second
+
+// readDmi returns the trimmed contents of a DMI field
+// or an empty string if the field could not be read.
+func readDmi(field string) string {
+
+	data, err := os.ReadFile(filepath.Join(DmiDir, field))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// resolveProviders creates the providers selected by
+// the Manager's Provider field, which is a comma-separated
+// list of registered names where "auto" expands to the
+// first cloud provider which detects itself on the current
+// machine. Tags from later providers override earlier ones.
+func (m *Manager) resolveProviders(timeout time.Duration) ([]Provider, error) {
+
+	logger := m.GetLogger()
+
+	var result []Provider
+
+	// Avoid adding the same provider twice
+	seen := make(map[string]bool)
+
+	for _, name := range strings.Split(m.Provider, ",") {
+
+		name = strings.TrimSpace(name)
+
+		if name != "auto" {
+
+			newProvider, found := Providers[name]
+			if !found {
+				return nil, fmt.Errorf("unsupported provider: %s", name)
+			}
+
+			if !seen[name] {
+				seen[name] = true
+				result = append(result, newProvider(m))
+			}
+
+			continue
+		}
+
+		var candidates []Provider
+		for _, name := range DetectOrder {
+
+			newProvider, found := Providers[name]
+			if found && !seen[name] {
+				candidates = append(candidates, newProvider(m))
+			}
+		}
+
+		detected := detectProvider(candidates, timeout)
+		if detected != nil {
+			logger.Debug("detected provider: " + detected.Name())
+
+			seen[detected.Name()] = true
+			result = append(result, detected)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("could not detect provider")
+	}
+
+	return result, nil
+}
+
+// detectProvider returns the first of the candidates which
+// detects itself, or nil if none do. DMI data is checked
+// for all of them first, so that the metadata services of
+// other clouds are only probed when it's inconclusive.
+func detectProvider(candidates []Provider, timeout time.Duration) Provider {
+
+	for _, provider := range candidates {
+		if d, ok := provider.(dmiDetector); ok && d.detectDmi() {
+			return provider
+		}
+	}
+
+	// Don't spend longer probing than fetching
+	if timeout > detectTimeout {
+		timeout = detectTimeout
+	}
+
+	for _, provider := range candidates {
+
+		ctx, cancel := context.WithTimeout(
+			context.Background(), timeout,
+		)
+
+		detected := provider.Detect(ctx)
+		cancel()
+
+		// Only one cloud can be running systags
+		if detected {
+			return provider
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setTestDmi points DmiDir at a directory with the
+// given fields for the duration of the test
+func setTestDmi(t *testing.T, fields map[string]string) {
+
+	dir := t.TempDir()
+	for field, value := range fields {
+		writeTestFile(t, filepath.Join(dir, field), value+"\n")
+	}
+
+	previous := DmiDir
+	DmiDir = dir
+
+	t.Cleanup(func() {
+		DmiDir = previous
+	})
+}
+
+func TestResolveProvidersDmiFirst(t *testing.T) {
+
+	setTestDmi(t, map[string]string{
+		"product_name": "Google Compute Engine",
+	})
+
+	var probes atomic.Int32
+
+	// Stands in for every metadata service
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	m := NewManager()
+	m.Provider = "auto"
+	m.AwsImdsEndpoint = server.URL
+	m.GcpEndpoint = server.URL
+	m.AzureEndpoint = server.URL
+
+	providers, err := m.resolveProviders(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(providers) != 1 || providers[0].Name() != "gcp" {
+		t.Fatalf("got providers %v, want gcp", providers)
+	}
+
+	// DMI was conclusive, so aws was never probed
+	if n := probes.Load(); n != 0 {
+		t.Errorf("metadata services were probed %d times, want 0", n)
+	}
+}
+
+func TestResolveProvidersProbe(t *testing.T) {
+
+	setTestDmi(t, nil)
+
+	// Only the azure metadata service responds
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"compute": {}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	m := NewManager()
+	m.Provider = "auto"
+	m.AwsImdsEndpoint = server.URL
+	m.GcpEndpoint = server.URL
+	m.AzureEndpoint = server.URL
+
+	providers, err := m.resolveProviders(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(providers) != 1 || providers[0].Name() != "azure" {
+		t.Fatalf("got providers %v, want azure", providers)
+	}
+}
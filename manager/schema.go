@@ -0,0 +1,260 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// SchemaFile is the optional file in the ConfigDir which
+// declares the rules tags must follow, it's never read as
+// config tags. Like config files, the one in the last
+// directory is used.
+const SchemaFile = "schema.json"
+
+// Types of values which keys of a schema can have
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeBool   = "bool"
+	TypeEnum   = "enum"
+)
+
+// KeyRule declares what values a key can have.
+type KeyRule struct {
+	// One of string, int, bool, or enum,
+	// defaults to string when left empty
+	Type string `json:"type,omitempty"`
+
+	// The allowed values of enum keys
+	Values []string `json:"values,omitempty"`
+
+	// A regular expression the whole value must match
+	Pattern string `json:"pattern,omitempty"`
+
+	// Whether the merged tags must have the key
+	Required bool `json:"required,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Schema declares the rules for tags by key. Keys which
+// aren't in the schema can have any value.
+type Schema struct {
+	Keys map[string]KeyRule `json:"keys"`
+}
+
+// SchemaViolation describes a tag which doesn't follow
+// the Manager's schema.
+type SchemaViolation struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (v *SchemaViolation) Error() string {
+
+	if v.Value == "" {
+		return fmt.Sprintf("tag %s %s", v.Key, v.Reason)
+	}
+
+	return fmt.Sprintf("tag %s=%s %s", v.Key, v.Value, v.Reason)
+}
+
+// SchemaError is returned when tags violate the schema.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+
+	if len(e.Violations) == 1 {
+		return e.Violations[0].Error()
+	}
+
+	return fmt.Sprintf("%d tags violate the schema", len(e.Violations))
+}
+
+// compile checks the rules of the schema and prepares
+// their patterns
+func (s *Schema) compile() error {
+
+	for key, rule := range s.Keys {
+
+		switch rule.Type {
+		case "", TypeString, TypeInt, TypeBool:
+			if len(rule.Values) > 0 {
+				return fmt.Errorf("key %s: values are only allowed for enum", key)
+			}
+
+		case TypeEnum:
+			if len(rule.Values) == 0 {
+				return fmt.Errorf("key %s: enum needs values", key)
+			}
+
+		default:
+			return fmt.Errorf("key %s: unsupported type: %s", key, rule.Type)
+		}
+
+		if rule.Pattern != "" {
+
+			// The whole value has to match
+			pattern, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key, err)
+			}
+
+			rule.pattern = pattern
+		}
+
+		s.Keys[key] = rule
+	}
+
+	return nil
+}
+
+// ValidateValue checks the value of a single tag against
+// the rule for its key, if there is one.
+func (s *Schema) ValidateValue(key string, value string) error {
+
+	if s == nil {
+		return nil
+	}
+
+	rule, found := s.Keys[key]
+	if !found {
+		return nil
+	}
+
+	violation := func(reason string) error {
+		return &SchemaViolation{Key: key, Value: value, Reason: reason}
+	}
+
+	switch rule.Type {
+	case TypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return violation("is not an int")
+		}
+
+	case TypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return violation("is not a bool")
+		}
+
+	case TypeEnum:
+		allowed := false
+		for _, v := range rule.Values {
+			if v == value {
+				allowed = true
+			}
+		}
+
+		if !allowed {
+			return violation(fmt.Sprintf("is not one of %v", rule.Values))
+		}
+	}
+
+	if rule.pattern != nil && !rule.pattern.MatchString(value) {
+		return violation("does not match " + rule.Pattern)
+	}
+
+	return nil
+}
+
+// ValidateValues checks the value of every tag against
+// the schema and returns the violations sorted by key.
+func (s *Schema) ValidateValues(tags Tags) []SchemaViolation {
+
+	if s == nil {
+		return nil
+	}
+
+	var result []SchemaViolation
+
+	for key, value := range tags {
+
+		var violation *SchemaViolation
+		if errors.As(s.ValidateValue(key, value), &violation) {
+			result = append(result, *violation)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+// Validate is like ValidateValues, but also checks
+// whether the required keys are present.
+func (s *Schema) Validate(tags Tags) []SchemaViolation {
+
+	if s == nil {
+		return nil
+	}
+
+	result := s.ValidateValues(tags)
+
+	for key, rule := range s.Keys {
+
+		if _, found := tags[key]; rule.Required && !found {
+			result = append(result, SchemaViolation{Key: key, Reason: "is required"})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+// loadSchema reads the SchemaFile from the ConfigDir,
+// resulting in nil when there is none
+func (m *Manager) loadSchema() (*Schema, error) {
+
+	schemaFile := findConfigFile(m.ConfigDirs(), SchemaFile)
+	if schemaFile == "" {
+		return nil, nil
+	}
+
+	m.GetLogger().Debug("reading schema file: " + schemaFile)
+
+	// Attempt to read the contents of the file
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{}
+	// Try and parse the file as a schema JSON object
+	err = json.Unmarshal(data, schema)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", schemaFile, err)
+	}
+
+	err = schema.compile()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", schemaFile, err)
+	}
+
+	return schema, nil
+}
+
+// Schema returns the schema from the ConfigDir, or nil
+// when there is none.
+func (m *Manager) Schema() *Schema {
+
+	return m.schema
+}
+
+// Validate checks the merged tags against the schema.
+func (m *Manager) Validate() []SchemaViolation {
+
+	return m.schema.Validate(m.GetTags(false, "", ""))
+}
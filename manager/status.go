@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RemoteStatus records the outcome of the most recent
+// attempt at fetching remote tags, so the age of the
+// last-known-good remote tags can be determined.
+type RemoteStatus struct {
+	// When the last fetch was attempted
+	Time time.Time `json:"time"`
+
+	// The providers used by the last fetch
+	Provider string `json:"provider"`
+
+	// Whether the last fetch succeeded
+	Success bool `json:"success"`
+
+	// Whether only some of the tags were fetched
+	Partial bool `json:"partial,omitempty"`
+
+	// Why the last fetch failed, if it did
+	Error string `json:"error,omitempty"`
+
+	// When the remote tags were last fetched
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// statusFile returns the path of the status file
+func (m *Manager) statusFile() string {
+	return filepath.Join(m.SystemDir, "status.json")
+}
+
+// loadStatus reads the status file from the SystemDir.
+// Remote files written before the status file existed
+// fall back to using their modification time, which is
+// written to the status file before the remote file is
+// next saved, since saving would reset it.
+func (m *Manager) loadStatus() error {
+
+	logger := m.GetLogger()
+
+	status := RemoteStatus{}
+	statusFile := m.statusFile()
+	m.statusInferred = false
+
+	// Check if status file exists and then read it
+	if _, err := os.Stat(statusFile); err == nil {
+
+		logger.Debug("reading status file: " + statusFile)
+
+		// Attempt to read the contents of the file
+		statusBytes, err := os.ReadFile(statusFile)
+		if err != nil {
+			return err
+		}
+
+		// Try and parse the file as a status JSON object
+		err = json.Unmarshal(statusBytes, &status)
+		if err != nil {
+			return err
+		}
+
+	} else {
+
+		remoteFile := filepath.Join(m.SystemDir, "remote.json")
+
+		if info, err := os.Stat(remoteFile); err == nil {
+			status.Time = info.ModTime()
+			status.Success = true
+			status.LastSuccess = info.ModTime()
+			m.statusInferred = true
+		}
+	}
+
+	m.status = status
+	return nil
+}
+
+// SaveStatus writes the status of the most recent
+// remote fetch to the status file in the SystemDir.
+func (m *Manager) SaveStatus() error {
+
+	logger := m.GetLogger()
+	statusFile := m.statusFile()
+
+	// Attempt to convert the status to JSON
+	statusJson, err := json.MarshalIndent(m.status, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("writing status file: " + statusFile)
+
+	// Attempt to write the current status
+	err = writeFile(statusFile, statusJson, m.fileAttrs())
+	if err != nil {
+		return err
+	}
+
+	m.statusInferred = false
+	return nil
+}
+
+// recordStatus updates the in-memory status with the
+// outcome of a remote fetch using the given providers.
+// A fetch that only kept partial tags isn't a success.
+func (m *Manager) recordStatus(providers []Provider, partial error, err error) {
+
+	var names []string
+	for _, provider := range providers {
+		names = append(names, provider.Name())
+	}
+
+	// Providers couldn't be resolved
+	if len(names) == 0 {
+		names = append(names, m.Provider)
+	}
+
+	m.status.Time = time.Now()
+	m.status.Provider = strings.Join(names, ",")
+	m.status.Success = err == nil && partial == nil
+	m.status.Partial = err == nil && partial != nil
+	m.status.Error = ""
+
+	if err != nil {
+		m.status.Error = err.Error()
+	} else if partial != nil {
+		m.status.Error = partial.Error()
+	} else {
+		m.status.LastSuccess = m.status.Time
+	}
+}
+
+// RemoteStatus returns the status of the most recent
+// attempt at fetching the Manager's remote tags.
+func (m *Manager) RemoteStatus() RemoteStatus {
+
+	return m.status
+}
+
+// RemoteAge returns how long ago the remote tags were
+// last fetched successfully, and false if they never were.
+func (m *Manager) RemoteAge() (time.Duration, bool) {
+
+	if m.status.LastSuccess.IsZero() {
+		return 0, false
+	}
+
+	return time.Since(m.status.LastSuccess), true
+}
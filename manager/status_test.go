@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadStatusRemoteFileFallback(t *testing.T) {
+
+	dir := t.TempDir()
+
+	m := NewManager()
+	m.ConfigDir = filepath.Join(dir, "config.d")
+	m.SystemDir = filepath.Join(dir, "system")
+	m.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if err := m.makeDir(m.SystemDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remote tags written before the status file existed
+	remoteFile := filepath.Join(m.SystemDir, "remote.json")
+	writeTestFile(t, remoteFile, `{"env": "prod"}`)
+
+	fetched := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(remoteFile, fetched, fetched); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.LoadFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.SetTag("role", "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	// This rewrites the remote file
+	if err := m.SaveFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.LoadFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The age still comes from the original fetch
+	age, known := m.RemoteAge()
+	if !known || age < 47*time.Hour {
+		t.Errorf("got remote age %v (known %v), want about 48h", age, known)
+	}
+
+	if !m.RemoteStatus().LastSuccess.Equal(fetched) {
+		t.Errorf("got last success %v, want %v", m.RemoteStatus().LastSuccess, fetched)
+	}
+}
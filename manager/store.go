@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Store persists layers of tags, such as the remote
+// and system tags, between runs of systags.
+type Store interface {
+	// Load returns the tags saved for the layer,
+	// which are empty when it was never saved
+	Load(layer string) (Tags, error)
+
+	// Save replaces the tags of all the given
+	// layers in a single transaction, so either
+	// every layer is changed or none of them are
+	Save(layers map[string]Tags) error
+}
+
+// HistoryStore is a Store which keeps the previous
+// remote and system tags whenever they're replaced.
+type HistoryStore interface {
+	Store
+
+	// History returns the generations that are
+	// still kept, ordered from newest to oldest
+	History() ([]Generation, error)
+}
+
+// ErrNoHistory is returned when the Manager's Store
+// doesn't keep any history.
+var ErrNoHistory = errors.New("store does not keep history")
+
+// NewStore creates a Store from the Manager's options
+type NewStore func(*Manager) Store
+
+// Stores contains every registered Store by name
+var Stores = map[string]NewStore{
+	"file":   NewFileStore,
+	"bolt":   NewBoltStore,
+	"memory": NewMemoryStore,
+}
+
+// copyTags returns a copy of tags which is never nil
+func copyTags(tags Tags) Tags {
+
+	result := make(Tags, len(tags))
+	for key, value := range tags {
+		result[key] = value
+	}
+
+	return result
+}
+
+// sameTags reports whether both sets of tags are equal
+func sameTags(a Tags, b Tags) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		if other, found := b[key]; !found || other != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+type memoryStore struct {
+	m *Manager
+
+	mutex   sync.Mutex
+	layers  map[string]Tags
+	history []Generation
+}
+
+// NewMemoryStore creates a Store which only keeps the
+// tags in memory, for library users and tests. The CLI
+// rejects it since nothing would outlive the command.
+func NewMemoryStore(m *Manager) Store {
+
+	return &memoryStore{
+		m:      m,
+		layers: make(map[string]Tags),
+	}
+}
+
+func (s *memoryStore) Load(layer string) (Tags, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return copyTags(s.layers[layer]), nil
+}
+
+func (s *memoryStore) Save(layers map[string]Tags) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changed := false
+	for layer, tags := range layers {
+		if !sameTags(s.layers[layer], tags) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	// Nothing to keep before the first save
+	if s.m.HistoryLimit > 0 && len(s.layers) > 0 {
+
+		now := time.Now().UTC()
+
+		// Newest generations go first
+		s.history = append([]Generation{{
+			ID:     now.Format(generationLayout),
+			Time:   now,
+			Remote: copyTags(s.layers["remote"]),
+			System: copyTags(s.layers["system"]),
+		}}, s.history...)
+
+		if len(s.history) > s.m.HistoryLimit {
+			s.history = s.history[:s.m.HistoryLimit]
+		}
+	}
+
+	for layer, tags := range layers {
+		s.layers[layer] = copyTags(tags)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) History() ([]Generation, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := make([]Generation, len(s.history))
+	copy(result, s.history)
+
+	return result, nil
+}
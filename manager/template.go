@@ -0,0 +1,374 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TemplateError is returned when a templated value is
+// invalid or can't be expanded.
+type TemplateError struct {
+	Key string
+	Err error
+
+	// The config file of the value
+	Source string
+}
+
+func (e *TemplateError) Error() string {
+
+	if e.Source != "" {
+		return fmt.Sprintf("%s: tag %s: %v", e.Source, e.Key, e.Err)
+	}
+
+	return fmt.Sprintf("tag %s: %v", e.Key, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUndefinedTag is returned when a templated value
+// references a tag which doesn't exist without a default.
+var ErrUndefinedTag = errors.New("undefined tag")
+
+// CycleError is returned when templated values end up
+// referencing themselves.
+type CycleError struct {
+	Cycle []string
+
+	// The config files of the values in the cycle
+	Sources []string
+}
+
+func (e *CycleError) Error() string {
+
+	msg := "template cycle: " + strings.Join(e.Cycle, " -> ")
+
+	if len(e.Sources) > 0 {
+		msg += " (in " + strings.Join(e.Sources, ", ") + ")"
+	}
+
+	return msg
+}
+
+// templateFuncs are the functions which can be applied
+// to referenced values, replace takes its arguments
+var templateFuncs = map[string]func(string, []string) (string, error){
+	"lower": func(value string, _ []string) (string, error) {
+		return strings.ToLower(value), nil
+	},
+	"upper": func(value string, _ []string) (string, error) {
+		return strings.ToUpper(value), nil
+	},
+	"replace": func(value string, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", errors.New("replace needs 2 arguments")
+		}
+
+		return strings.ReplaceAll(value, args[0], args[1]), nil
+	},
+}
+
+// templateRef is a reference to another tag in a value
+type templateRef struct {
+	key        string
+	def        string
+	hasDefault bool
+	funcs      []string
+	args       [][]string
+}
+
+// parseRef parses the text between ${ and }, which has
+// the form key[:-default][|func...]
+func parseRef(text string) (templateRef, error) {
+
+	parts := strings.Split(text, "|")
+
+	ref := templateRef{key: parts[0]}
+	ref.key, ref.def, ref.hasDefault = strings.Cut(parts[0], ":-")
+
+	if ref.key == "" {
+		return ref, errors.New("empty reference: ${" + text + "}")
+	}
+
+	for _, call := range parts[1:] {
+
+		name, rest, hasArgs := strings.Cut(call, "(")
+
+		var args []string
+		if hasArgs {
+			if !strings.HasSuffix(rest, ")") {
+				return ref, errors.New("unterminated call: " + call)
+			}
+
+			args = strings.Split(strings.TrimSuffix(rest, ")"), ",")
+		}
+
+		if _, found := templateFuncs[name]; !found {
+			return ref, errors.New("unknown function: " + name)
+		}
+
+		ref.funcs = append(ref.funcs, name)
+		ref.args = append(ref.args, args)
+	}
+
+	return ref, nil
+}
+
+// expandTemplate replaces every ${...} reference in value
+// using lookup, where $${ is an escaped ${
+func expandTemplate(value string, lookup func(templateRef) (string, error)) (string, error) {
+
+	var out strings.Builder
+
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			out.WriteString(value)
+			return out.String(), nil
+		}
+
+		// Escaped, so output it without the extra $
+		if start > 0 && value[start-1] == '$' {
+			out.WriteString(value[:start-1] + "${")
+			value = value[start+2:]
+			continue
+		}
+
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			return "", errors.New("unterminated reference: " + value[start:])
+		}
+
+		ref, err := parseRef(value[start+2 : start+end])
+		if err != nil {
+			return "", err
+		}
+
+		result, err := lookup(ref)
+		if err != nil {
+			return "", err
+		}
+
+		for i, name := range ref.funcs {
+
+			result, err = templateFuncs[name](result, ref.args[i])
+			if err != nil {
+				return "", err
+			}
+		}
+
+		out.WriteString(value[:start])
+		out.WriteString(result)
+		value = value[start+end+1:]
+	}
+}
+
+// resolver expands the templated values of merged tags
+type resolver struct {
+	tags      Tags
+	templated map[string]bool
+	sources   map[string]string
+	resolved  map[string]string
+	stack     []string
+}
+
+// resolve returns the value of key with its references
+// expanded, or an error naming the cycle it's part of
+func (r *resolver) resolve(key string) (string, error) {
+
+	if value, found := r.resolved[key]; found {
+		return value, nil
+	}
+
+	value := r.tags[key]
+	if !r.templated[key] {
+		return value, nil
+	}
+
+	for i, other := range r.stack {
+		if other == key {
+			cycle := append(append([]string{}, r.stack[i:]...), key)
+
+			var sources []string
+			for _, other := range r.stack[i:] {
+				if source := r.sources[other]; source != "" && !slices.Contains(sources, source) {
+					sources = append(sources, source)
+				}
+			}
+
+			return "", &CycleError{Cycle: cycle, Sources: sources}
+		}
+	}
+
+	r.stack = append(r.stack, key)
+	defer func() {
+		r.stack = r.stack[:len(r.stack)-1]
+	}()
+
+	result, err := expandTemplate(value, func(ref templateRef) (string, error) {
+
+		if _, found := r.tags[ref.key]; !found {
+			if ref.hasDefault {
+				return ref.def, nil
+			}
+
+			return "", fmt.Errorf("%w: %s", ErrUndefinedTag, ref.key)
+		}
+
+		return r.resolve(ref.key)
+	})
+
+	if err != nil {
+
+		// Report errors against the innermost tag
+		var cycleErr *CycleError
+		var templateErr *TemplateError
+		if errors.As(err, &cycleErr) || errors.As(err, &templateErr) {
+			return "", err
+		}
+
+		return "", &TemplateError{Key: key, Err: err, Source: r.sources[key]}
+	}
+
+	r.resolved[key] = result
+	return result, nil
+}
+
+// mergeTags combines the tags of every layer, from the
+// lowest priority, following the policy for locked keys,
+// and reports which of the resulting values are templates,
+// which only config files can set
+func (m *Manager) mergeTags() (Tags, map[string]bool) {
+
+	combined := make(Tags)
+	templated := make(map[string]bool)
+
+	for _, layer := range m.layers {
+		for key, value := range m.tags[layer.Name] {
+
+			// Locked keys only come from their layer
+			if !m.policy.allows(key, layer.Name) {
+				continue
+			}
+
+			combined[key] = value
+			templated[key] = layer.Source == SourceDir || layer.Source == SourceFile
+		}
+	}
+
+	return combined, templated
+}
+
+// templateSource returns the config file which the
+// effective value of a templated key comes from
+func (m *Manager) templateSource(key string) string {
+
+	for _, value := range m.explainKey(key) {
+		if !value.Ignored {
+			return value.Source
+		}
+	}
+
+	return ""
+}
+
+// newResolver creates a resolver for the merged tags
+func (m *Manager) newResolver() *resolver {
+
+	combined, templated := m.mergeTags()
+
+	// Errors should say which file to fix
+	sources := make(map[string]string)
+	for key, isTemplate := range templated {
+		if isTemplate && strings.Contains(combined[key], "${") {
+			sources[key] = m.templateSource(key)
+		}
+	}
+
+	return &resolver{
+		tags:      combined,
+		templated: templated,
+		sources:   sources,
+		resolved:  make(map[string]string),
+	}
+}
+
+// resolveTags returns the merged tags with templated
+// values expanded. Values which can't be expanded are
+// kept as is, and the errors are returned with them.
+func (m *Manager) resolveTags() (Tags, []error) {
+
+	r := m.newResolver()
+
+	var errs []error
+
+	result := make(Tags, len(r.tags))
+	for key, value := range r.tags {
+
+		resolved, err := r.resolve(key)
+		if err != nil {
+			errs = append(errs, err)
+			resolved = value
+		}
+
+		result[key] = resolved
+	}
+
+	return result, errs
+}
+
+// TemplateErrors returns the errors of invalid templates
+// and cycles, leaving out undefined tags since they may
+// still be fetched, such as remote tags before an update.
+func (m *Manager) TemplateErrors() []error {
+
+	_, errs := m.resolveTags()
+
+	var result []error
+	for _, err := range errs {
+		if !errors.Is(err, ErrUndefinedTag) {
+			result = append(result, err)
+		}
+	}
+
+	// Map order would make the first one random
+	slices.SortFunc(result, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+
+	return result
+}
+
+// checkTemplates fails on invalid templates and cycles
+// in StrictConfig mode. Otherwise they're treated like
+// undefined tags, which are warned about when they're
+// used while their values are kept as is.
+func (m *Manager) checkTemplates() error {
+
+	if !m.StrictConfig {
+		return nil
+	}
+
+	if errs := m.TemplateErrors(); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// effectiveTags returns the merged tags with templated
+// values expanded, warning about those which can't be
+func (m *Manager) effectiveTags() Tags {
+
+	tags, errs := m.resolveTags()
+
+	for _, err := range errs {
+		m.GetLogger().Warn(err.Error())
+	}
+
+	return tags
+}
@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+
+	tags := Tags{"env": "Prod", "name": "web-1"}
+
+	lookup := func(ref templateRef) (string, error) {
+
+		value, found := tags[ref.key]
+		if !found {
+			if ref.hasDefault {
+				return ref.def, nil
+			}
+
+			return "", ErrUndefinedTag
+		}
+
+		return value, nil
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "plain", want: "plain"},
+		{value: "${name}.${env}", want: "web-1.Prod"},
+		{value: "$${name}", want: "${name}"},
+		{value: "$$${name}", want: "$${name}"},
+		{value: "${team:-ads}", want: "ads"},
+		{value: "${env:-dev}", want: "Prod"},
+		{value: "${team:-}", want: ""},
+		{value: "${env|lower}", want: "prod"},
+		{value: "${env|upper}", want: "PROD"},
+		{value: "${name|replace(-,_)|upper}", want: "WEB_1"},
+		{value: "${team:-a-b|replace(-,.)}", want: "a.b"},
+	}
+
+	for _, test := range tests {
+
+		got, err := expandTemplate(test.value, lookup)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%s expanded to %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestExpandTemplateErrors(t *testing.T) {
+
+	lookup := func(ref templateRef) (string, error) {
+
+		if ref.hasDefault {
+			return ref.def, nil
+		}
+
+		return "", ErrUndefinedTag
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "echo ${}", want: "empty reference"},
+		{value: "${env", want: "unterminated reference"},
+		{value: "${env|title}", want: "unknown function: title"},
+		{value: "${env:-x|replace(a)}", want: "replace needs 2 arguments"},
+		{value: "${env:-x|replace(a,b}", want: "unterminated call"},
+		{value: "${env}", want: ErrUndefinedTag.Error()},
+	}
+
+	for _, test := range tests {
+
+		_, err := expandTemplate(test.value, lookup)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %s", test.value, err, test.want)
+		}
+	}
+}
+
+// newTemplateTestManager creates a Manager whose config
+// directory holds the given files
+func newTemplateTestManager(t *testing.T, files map[string]string) *Manager {
+
+	dir := t.TempDir()
+
+	m := NewManager()
+	m.ConfigDir = filepath.Join(dir, "config.d")
+	m.SystemDir = filepath.Join(dir, "system")
+	m.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if err := m.makeDir(m.ConfigDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		writeTestFile(t, filepath.Join(m.ConfigDir, name), data)
+	}
+
+	return m
+}
+
+func TestResolveTemplates(t *testing.T) {
+
+	m := newTemplateTestManager(t, map[string]string{
+		"10-base.json": `{"env": "prod", "host": "${name}.${env|upper}"}`,
+		"20-name.json": `{"name": "web-${id:-0}", "literal": "$${env}"}`,
+	})
+
+	if err := m.LoadFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := m.TemplateErrors(); len(errs) > 0 {
+		t.Fatalf("unexpected template errors: %v", errs)
+	}
+
+	tags := m.GetTags(false, "", "")
+
+	assertTags(t, tags, Tags{
+		"env":     "prod",
+		"host":    "web-0.PROD",
+		"name":    "web-0",
+		"literal": "${env}",
+	})
+
+	if got := m.GetTag("host", ""); got != "web-0.PROD" {
+		t.Errorf("GetTag returned %q, want web-0.PROD", got)
+	}
+
+	prov, _ := m.ExplainTag("host")
+	if prov.Value != "web-0.PROD" || prov.Template != "${name}.${env|upper}" {
+		t.Errorf("unexpected provenance: %+v", prov)
+	}
+}
+
+func TestResolveTemplatesCycle(t *testing.T) {
+
+	m := newTemplateTestManager(t, map[string]string{
+		"10-a.json": `{"a": "${b}"}`,
+		"20-b.json": `{"b": "x-${a}"}`,
+	})
+
+	if err := m.LoadFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := m.TemplateErrors()
+	if len(errs) != 2 {
+		t.Fatalf("got %d template errors, want 2: %v", len(errs), errs)
+	}
+
+	var cycleErr *CycleError
+	if !errors.As(errs[0], &cycleErr) {
+		t.Fatalf("got error %v, want CycleError", errs[0])
+	}
+
+	if got := strings.Join(cycleErr.Cycle, " -> "); got != "a -> b -> a" {
+		t.Errorf("got cycle %s, want a -> b -> a", got)
+	}
+
+	// Both files are named so they can be fixed
+	if len(cycleErr.Sources) != 2 ||
+		filepath.Base(cycleErr.Sources[0]) != "10-a.json" ||
+		filepath.Base(cycleErr.Sources[1]) != "20-b.json" {
+		t.Errorf("got sources %v, want 10-a.json and 20-b.json", cycleErr.Sources)
+	}
+
+	// The values are kept as is
+	if got := m.GetTag("a", ""); got != "${b}" {
+		t.Errorf("GetTag returned %q, want ${b}", got)
+	}
+}
+
+func TestLoadFilesInvalidTemplate(t *testing.T) {
+
+	m := newTemplateTestManager(t, map[string]string{
+		"10-cmd.json": `{"cmd": "echo ${}", "env": "prod"}`,
+	})
+
+	// Other tags stay usable
+	if err := m.LoadFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.GetTag("cmd", ""); got != "echo ${}" {
+		t.Errorf("GetTag returned %q, want the raw value", got)
+	}
+
+	if got := m.GetTag("env", ""); got != "prod" {
+		t.Errorf("GetTag returned %q, want prod", got)
+	}
+
+	m.StrictConfig = true
+
+	err := m.LoadFiles()
+
+	var templateErr *TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("got error %v, want TemplateError", err)
+	}
+
+	if templateErr.Key != "cmd" || filepath.Base(templateErr.Source) != "10-cmd.json" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "10-cmd.json: tag cmd: empty reference") {
+		t.Errorf("error doesn't name the file: %v", err)
+	}
+}